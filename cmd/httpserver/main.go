@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+	"github.com/jrooke/httpfromtcp/internal/server"
+)
+
+const port = 42069
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// HTML pages served by the demo handler
+const badRequestPage = `<html>
+  <head>
+    <title>400 Bad Request</title>
+  </head>
+  <body>
+    <h1>Bad Request</h1>
+    <p>Your request honestly kinda sucked.</p>
+  </body>
+</html>
+`
+
+const internalErrorPage = `<html>
+  <head>
+    <title>500 Internal Server Error</title>
+  </head>
+  <body>
+    <h1>Internal Server Error</h1>
+    <p>Okay, you know what? This one is on me.</p>
+  </body>
+</html>
+`
+
+const okPage = `<html>
+  <head>
+    <title>200 OK</title>
+  </head>
+  <body>
+    <h1>Success!</h1>
+    <p>Your request was an absolute banger.</p>
+  </body>
+</html>
+`
+
+func main() {
+	s, err := server.Serve(port, handler)
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	log.Println("Server started on port", port)
+
+	// Block until Ctrl+C or a termination signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	// Let in-flight requests finish, but not forever
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down gracefully, closing: %v", err)
+		s.Close()
+		return
+	}
+	log.Println("Server gracefully stopped")
+}
+
+// handler routes the demo endpoints by path, ignoring any query:
+// /yourproblem → 400, /myproblem → 500, anything else → 200
+// A target that doesn't parse (e.g. a bad percent-encoding) is a 400 too.
+func handler(w *response.Writer, req *request.Request) {
+	u, err := req.URL()
+	if err != nil {
+		writeHTML(w, response.StatusBadRequest, badRequestPage)
+		return
+	}
+
+	switch u.Path {
+	case "/yourproblem":
+		writeHTML(w, response.StatusBadRequest, badRequestPage)
+	case "/myproblem":
+		writeHTML(w, response.StatusInternalServerError, internalErrorPage)
+	default:
+		writeHTML(w, response.StatusOK, okPage)
+	}
+}
+
+// writeHTML writes a complete response with an HTML body
+func writeHTML(w *response.Writer, statusCode response.StatusCode, page string) {
+	h := response.GetDefaultHeaders(len(page))
+	h.Set("Content-Type", "text/html")
+	// The demo keeps connections alive, so drop the default Connection: close
+	h.Delete("Connection")
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		log.Printf("error writing status line: %v", err)
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		log.Printf("error writing headers: %v", err)
+		return
+	}
+	if _, err := w.WriteBody([]byte(page)); err != nil {
+		log.Printf("error writing body: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// streamecho shows how to take a connection over after an Upgrade and
+// stream in both directions at once. Clients upgrade to the made-up "echo"
+// protocol, after which every line they send is echoed back while the server
+// also sends a tick every few seconds on its own.
+//
+// Try it with:
+//
+//	(printf 'GET /echo HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n'; cat) | nc localhost 42070
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+	"github.com/jrooke/httpfromtcp/internal/server"
+)
+
+const port = 42070
+
+const (
+	// idleTimeout closes the stream when the client sends nothing for this long
+	idleTimeout = 60 * time.Second
+
+	// writeTimeout bounds every write, so a client that stops reading can't pin us
+	writeTimeout = 10 * time.Second
+
+	// tickInterval is how often the server speaks unprompted
+	tickInterval = 5 * time.Second
+
+	// shutdownTimeout is how long in-flight requests get to finish on shutdown
+	shutdownTimeout = 10 * time.Second
+)
+
+func main() {
+	s, err := server.Serve(port, handler)
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	log.Println("Server started on port", port)
+
+	// Block until Ctrl+C or a termination signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	// Let in-flight requests finish, but not forever. Upgraded streams
+	// belong to their handlers and end when the process exits.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down gracefully, closing: %v", err)
+		s.Close()
+		return
+	}
+	log.Println("Server gracefully stopped")
+}
+
+// handler upgrades /echo to the echo protocol and answers everything else with 426
+func handler(w *response.Writer, req *request.Request) {
+	conn, rw, err := server.Upgrade(w, req, "echo")
+	if errors.Is(err, server.ERROR_NOT_UPGRADE) {
+		body := "Upgrade to echo to use this server\n"
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Upgrade", "echo")
+		h.Set("Connection", "Upgrade, close")
+		w.WriteStatusLine(response.StatusUpgradeRequired)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+		return
+	}
+	if err != nil {
+		log.Printf("error upgrading %s: %v", req.RemoteAddr, err)
+		return
+	}
+
+	// The handler may return while the stream carries on, it owns the connection now
+	go stream(conn, rw)
+}
+
+// stream runs the echo protocol until either side stops.
+// Reading and writing happen on separate goroutines so ticks go out
+// while the reader is blocked waiting for the client.
+func stream(conn net.Conn, rw *bufio.ReadWriter) {
+	defer conn.Close()
+
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+
+	// done stops the reader if the writer gives up first
+	done := make(chan struct{})
+	defer close(done)
+
+	// Reader: the bufio.Reader from Hijack must be used, not conn directly,
+	// since it holds anything the client sent along with the request
+	go func() {
+		defer close(lines)
+		for {
+			// Renew the idle deadline before each line
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case lines <- line:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	// Writer: all writes happen here, so they never interleave
+	for {
+		var out string
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if err := <-readErr; err != io.EOF {
+					log.Printf("echo stream with %s ended: %v", conn.RemoteAddr(), err)
+				}
+				return
+			}
+			out = "echo: " + line
+		case now := <-ticker.C:
+			out = "tick " + now.Format(time.TimeOnly) + "\n"
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := rw.WriteString(out); err != nil {
+			log.Printf("error writing to %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if err := rw.Flush(); err != nil {
+			log.Printf("error writing to %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
@@ -4,30 +4,66 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sort"
 
 	"github.com/jrooke/httpfromtcp/internal/request"
 )
 
+const port = ":42069"
+
 func main() {
 
-	listener, err := net.Listen("tcp", ":42069")
+	listener, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatal("error", "error", err)
 	}
+	defer listener.Close()
+
+	fmt.Println("Listening for TCP traffic on", port)
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			log.Fatal("error", "error", err)
 		}
+		fmt.Println("Accepted connection from", conn.RemoteAddr())
 
+		// A bad request only ends its own connection, keep accepting
 		r, err := request.RequestFromReader(conn)
 		if err != nil {
-			log.Fatal("error", "error", err)
+			log.Printf("error parsing request from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
 		}
-		fmt.Printf("Request line: \n")
-		fmt.Printf("- Method: %s\n", r.RequestLine.Method)
-		fmt.Printf("- Target: %s\n", r.RequestLine.RequestTarget)
-		fmt.Printf("- Version: %s\n", r.RequestLine.HttpVersion)
+
+		printRequest(r)
+
+		conn.Close()
+		fmt.Println("Connection to", conn.RemoteAddr(), "closed")
 	}
 }
+
+// printRequest prints the request line, headers and body of a parsed request
+func printRequest(r *request.Request) {
+	fmt.Printf("Request line:\n")
+	fmt.Printf("- Method: %s\n", r.RequestLine.Method)
+	fmt.Printf("- Target: %s\n", r.RequestLine.RequestTarget)
+	fmt.Printf("- Version: %s\n", r.RequestLine.HttpVersion)
+
+	// Sort header names so the output is the same on every run
+	names := make([]string, 0, len(r.Headers))
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Headers:\n")
+	for _, name := range names {
+		for _, value := range r.Headers.Values(name) {
+			fmt.Printf("- %s: %s\n", name, value)
+		}
+	}
+
+	fmt.Printf("Body:\n")
+	fmt.Printf("%s\n", string(r.Body))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+)
+
+const address = "localhost:42069"
+
+func main() {
+
+	// Resolve the address so bad hostnames fail before we start reading input
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		log.Fatal("error", "error", err)
+	}
+
+	// UDP is connectionless: "dialing" just fixes the destination for Write
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		log.Fatal("error", "error", err)
+	}
+	defer conn.Close()
+
+	fmt.Println("Sending UDP datagrams to", address)
+
+	// Every line typed becomes one datagram
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print("> ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// EOF (Ctrl+D) ends the session
+			return
+		}
+
+		if _, err := conn.Write([]byte(line)); err != nil {
+			log.Printf("error sending datagram: %v", err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	// Test: Fake clock stands still until advanced
+	f := NewFake(start)
+	assert.Equal(t, start, f.Now())
+	assert.Equal(t, start, f.Now())
+
+	f.Advance(90 * time.Second)
+	assert.Equal(t, start.Add(90*time.Second), f.Now())
+
+	f.Set(start)
+	assert.Equal(t, start, f.Now())
+
+	// Test: System clock follows the wall clock
+	assert.WithinDuration(t, time.Now(), System.Now(), time.Second)
+}
@@ -3,19 +3,36 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"strings"
 )
 
 // Headers is a map type that stores HTTP header key-value pairs
-// Key: header name (string)
-// Value: header value (string)
-// Example: "Content-Type" -> "application/json"
-type Headers map[string]string
+// Key: header name (string), canonicalized when set through Parse, Add or Set
+// Value: every value received or added for that name, one per field line (RFC 9110 section 5.3)
+// Example: "Set-Cookie" -> ["a=1", "b=2"]
+// Field names are case-insensitive, so prefer Get/Set/Add/Delete over indexing the map directly.
+type Headers map[string][]string
 
 var rn = []byte("\r\n")
 
+// ERROR_BARE_CR is returned when a CR appears in a line without the LF that should follow it
+var ERROR_BARE_CR = fmt.Errorf("bare CR in line")
+
+// ERROR_INVALID_FIELD_NAME is returned for a field name that is empty or has characters other than tchar,
+// including whitespace before the colon, which RFC 9112 section 5.1 says must be rejected
+var ERROR_INVALID_FIELD_NAME = fmt.Errorf("invalid field name")
+
+// ERROR_INVALID_FIELD_VALUE is returned for a field value containing a control
+// character other than HTAB, such as CR, LF, NUL or DEL
+var ERROR_INVALID_FIELD_VALUE = fmt.Errorf("invalid field value")
+
+// ERROR_OBS_FOLD is returned for a line starting with a space or tab, the obsolete
+// way of continuing the previous field's value (RFC 9112 section 5.2)
+var ERROR_OBS_FOLD = fmt.Errorf("obsolete line folding not allowed")
+
 // Constructor function to create empty instance of Headers
 func NewHeaders() Headers {
-	return map[string]string{}
+	return map[string][]string{}
 }
 
 // parseHeader parses a single header line (name: value format) into name and value strings.
@@ -34,56 +51,266 @@ func parseHeader(fieldLine []byte) (string, string, error) {
 
 	// Get the name and value
 	name := parts[0]
-	value := bytes.TrimSpace(parts[1])
+	value := parts[1]
 
-	// Header name cannot have trailing whitespace
-	if bytes.HasSuffix(name, []byte(" ")) {
-		return "", "", fmt.Errorf("malformed field name")
+	// Header name must be a token: no whitespace (before the colon or inside),
+	// control characters or separators. Lenient parsing here is a classic
+	// request smuggling vector, e.g. "Transfer-Encoding : chunked" read differently by a proxy.
+	if !IsToken(string(name)) {
+		return "", "", fmt.Errorf("%w: %q", ERROR_INVALID_FIELD_NAME, name)
 	}
 
+	// Control characters other than HTAB can't appear in a value (RFC 9110 section 5.5).
+	// CR and LF matter most: the line end was already stripped, so one left over
+	// would let a value smuggle in a line of its own. The check runs before the
+	// trim below, which would otherwise hide a CR at the end of the value.
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return "", "", fmt.Errorf("%w for %s", ERROR_INVALID_FIELD_VALUE, name)
+		}
+	}
+
+	// Only the optional whitespace around the value is trimmed: SP and HTAB
+	// Example: " \tapplication/json " → "application/json"
+	value = bytes.Trim(value, " \t")
+
 	return string(name), string(value), nil
 }
 
-// Parse is a method on the Headers type that extracts HTTP headers from raw bytes.
+// IsToken reports whether s is a non-empty token as defined in RFC 9110 section 5.6.2,
+// the syntax of field names and methods.
+// Example: "Content-Type" → true, "Content Type" → false
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTchar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTchar reports whether c may appear in a token:
+// letters, digits and !#$%&'*+-.^_`|~
+func isTchar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// Parse is a method on the Headers type that extracts one HTTP header from raw bytes.
 // Receiver (h Headers): called as h.Parse(data)
-// Input (data []byte): raw bytes containing header lines
+// Input (data []byte): raw bytes starting at a header line
 // Returns: (bytes consumed, all headers parsed, error)
+// Call it repeatedly, advancing past the consumed bytes, until done is true.
+// A repeated field name keeps each value separately (see Add).
 func (h Headers) Parse(data []byte) (int, bool, error) {
+	return h.ParseLine(data, false)
+}
 
-	read := 0
-	done := false
+// ParseLine is Parse with optional tolerance for lines ending in a bare LF
+// (allowBareLF), which some legacy clients send instead of \r\n
+func (h Headers) ParseLine(data []byte, allowBareLF bool) (int, bool, error) {
 
-	for {
-		// Find the next \r\n separator
-		idx := bytes.Index(data[read:], rn)
+	// Find the end of the line and the length of its separator
+	idx, sepLen, err := LineEnd(data, allowBareLF)
+	if err != nil {
+		return 0, false, err
+	}
 
-		// No separator = incomplete header, wait for more data
-		if idx == -1 {
-			break
-		}
+	// No separator = incomplete header, wait for more data
+	if idx == -1 {
+		return 0, false, nil
+	}
+
+	// Empty line (separator at position 0) = end of all headers
+	if idx == 0 {
+		return sepLen, true, nil
+	}
 
-		// Empty line (\r\n at position 0) = end of all headers
-		if idx == 0 {
-			read += len(rn)
-			done = true
-			break
+	// A line starting with whitespace continues the previous value (obs-fold)
+	// Joining it would mean guessing what the sender meant, so it's rejected
+	// rather than being mistaken for a new field
+	if data[0] == ' ' || data[0] == '\t' {
+		return 0, false, ERROR_OBS_FOLD
+	}
+
+	// Parse the header line (extract name and value)
+	name, value, err := parseHeader(data[:idx])
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Store header in the map, keeping earlier values of the same name
+	h.Add(name, value)
+
+	// Bytes consumed = header line + separator
+	return idx + sepLen, false, nil
+}
+
+// LineEnd finds the end of the first line in data
+// Input (allowBareLF bool): also accept a lone \n as the line terminator
+// Returns: (line length without separator, separator length, error)
+// A line length of -1 means no complete line yet.
+// With allowBareLF a CR anywhere but right before the LF is rejected,
+// so "\r" can never be mistaken for a terminator.
+func LineEnd(data []byte, allowBareLF bool) (int, int, error) {
+	if !allowBareLF {
+		return bytes.Index(data, rn), len(rn), nil
+	}
+
+	idx := bytes.IndexByte(data, '\n')
+	if idx == -1 {
+		// A CR is only acceptable as the very last byte, its LF may still be on the way
+		if cr := bytes.IndexByte(data, '\r'); cr != -1 && cr < len(data)-1 {
+			return 0, 0, ERROR_BARE_CR
 		}
+		return -1, 0, nil
+	}
+
+	lineLen := idx
+	sepLen := 1
+	if idx > 0 && data[idx-1] == '\r' {
+		lineLen = idx - 1
+		sepLen = 2
+	}
 
-		// Parse the header line (extract name and value)
-		name, value, err := parseHeader(data[:idx])
-		if err != nil {
-			return 0, false, err
+	if bytes.IndexByte(data[:lineLen], '\r') != -1 {
+		return 0, 0, ERROR_BARE_CR
+	}
+	return lineLen, sepLen, nil
+}
+
+// CanonicalName returns the canonical form of a header field name:
+// the first letter and every letter after a "-" upper case, the rest lower case.
+// Example: "content-LENGTH" → "Content-Length"
+func CanonicalName(name string) string {
+	b := []byte(name)
+	upper := true
+	for i, c := range b {
+		switch {
+		case upper && c >= 'a' && c <= 'z':
+			b[i] = c - ('a' - 'A')
+		case !upper && c >= 'A' && c <= 'Z':
+			b[i] = c + ('a' - 'A')
 		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// Get returns the value of a header, matching the name case-insensitively.
+// A field sent more than once comes back comma-joined, which RFC 9110
+// section 5.3 makes equivalent for list-based fields; use Values for the
+// others (Set-Cookie being the usual one).
+// Returns "" if the header is missing.
+func (h Headers) Get(name string) string {
+	return strings.Join(h[CanonicalName(name)], ", ")
+}
 
-		// Track bytes consumed (header line + separator)
-		read += idx + len(rn)
+// Has reports whether the header is present at all, even with an empty value
+func (h Headers) Has(name string) bool {
+	_, ok := h[CanonicalName(name)]
+	return ok
+}
 
-		// Store header in the map
-		h[name] = value
+// Set stores a header value, replacing any existing values for the same name
+func (h Headers) Set(name, value string) {
+	h[CanonicalName(name)] = []string{value}
+}
+
+// Add stores another value for a header, keeping the existing ones.
+// Each value is written on its own field line.
+// Example: Add("Set-Cookie", "b=2") after "a=1" → ["a=1", "b=2"]
+func (h Headers) Add(name, value string) {
+	name = CanonicalName(name)
+	h[name] = append(h[name], value)
+}
 
-		// Advance past the header we just processed
-		data = data[idx+len(rn):]
+// Delete removes a header, matching the name case-insensitively
+func (h Headers) Delete(name string) {
+	delete(h, CanonicalName(name))
+}
+
+// Values returns the values stored for a header name, one per field line,
+// as received: a value containing commas is not split.
+// Returns nil if the header is missing.
+func (h Headers) Values(name string) []string {
+	return h[CanonicalName(name)]
+}
+
+// List returns the elements of a header defined as a comma-separated list
+// (RFC 9110 section 5.6.1), e.g. Connection, Transfer-Encoding or Vary,
+// across every field line. Commas inside quoted strings don't split, and
+// empty elements are dropped. Don't use it on fields whose values may hold
+// a comma of their own, such as dates or Set-Cookie.
+// Example: "gzip, chunked" → ["gzip", "chunked"]
+func (h Headers) List(name string) []string {
+	var elements []string
+	for _, value := range h.Values(name) {
+		start, quoted := 0, false
+		for i := 0; i <= len(value); i++ {
+			switch {
+			case i < len(value) && value[i] == '\\' && quoted:
+				i++
+			case i < len(value) && value[i] == '"':
+				quoted = !quoted
+			case i == len(value) || (value[i] == ',' && !quoted):
+				if e := strings.TrimSpace(value[start:min(i, len(value))]); e != "" {
+					elements = append(elements, e)
+				}
+				start = i + 1
+			}
+		}
+	}
+	return elements
+}
+
+// WithCase returns a copy of h with each name spelled as in spellings
+// (canonical name → spelling, e.g. "X-Custom-Header" → "x-custom-HEADER").
+// Names without a spelling keep their canonical form. The copy is meant to
+// be passed straight to a writer for upstreams that are (wrongly) case-sensitive;
+// Get, Set and friends won't find the renamed fields in it.
+func (h Headers) WithCase(spellings map[string]string) Headers {
+	out := make(Headers, len(h))
+	for name, values := range h {
+		if spelling, ok := spellings[name]; ok {
+			name = spelling
+		}
+		out[name] = append([]string(nil), values...)
 	}
+	return out
+}
 
-	return read, done, nil
+// hopByHop lists the fields that only apply to a single connection (RFC 9110 section 7.6.1)
+// Proxy-Connection and Keep-Alive aren't standard but are still sent by older clients.
+var hopByHop = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopByHop deletes the hop-by-hop fields from h: every field named in
+// the Connection header plus the standard set above. A gateway calls it on a
+// request or response before forwarding it, since those fields describe the
+// connection they arrived on rather than the message.
+// Example: "Connection: close, X-Debug" removes Connection and X-Debug
+func RemoveHopByHop(h Headers) {
+	// Read the Connection list first, deleting Connection would lose it
+	for _, name := range h.List("Connection") {
+		h.Delete(name)
+	}
+	for _, name := range hopByHop {
+		h.Delete(name)
+	}
 }
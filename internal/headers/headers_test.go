@@ -11,8 +11,8 @@ import (
 // TestHeadersCreation creates a Headers instance and prints it
 func TestHeadersCreation(t *testing.T) {
 	h := Headers{
-		"Content-Type": "application/json",
-		"Host":         "example.com",
+		"Content-Type": {"application/json"},
+		"Host":         {"example.com"},
 	}
 	fmt.Println(h)
 }
@@ -25,7 +25,7 @@ func TestHeaderParsers(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["Host"])
+	assert.Equal(t, "localhost:42069", headers.Get("Host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -36,4 +36,207 @@ func TestHeaderParsers(t *testing.T) {
 	require.Error(t, err)
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
+
+	// Test: Invalid characters in the field name
+	for _, line := range []string{
+		"Host : localhost\r\n",
+		"X Forwarded: 1\r\n",
+		"X-Ctl\x01: 1\r\n",
+		"H(o)st: localhost\r\n",
+		": no name\r\n",
+	} {
+		headers = NewHeaders()
+		n, _, err = headers.Parse([]byte(line))
+		assert.ErrorIs(t, err, ERROR_INVALID_FIELD_NAME, line)
+		assert.Equal(t, 0, n)
+	}
+	assert.True(t, IsToken("X-Custom_Header.v2~!#$%&'*+^`|"))
+	assert.False(t, IsToken(""))
+
+	// Test: CR, LF, NUL or another control character inside a value
+	for _, line := range []string{
+		"X-Bad: a\x00b\r\n",
+		"X-Bad: a\nInjected: 1\r\n",
+		"X-Bad: a\rb\r\n",
+		"X-Bad: a\r\r\n",
+		"X-Bad: a\x1bb\r\n",
+		"X-Bad: a\x7f\r\n",
+	} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte(line))
+		assert.Error(t, err, line)
+	}
+	_, _, err = NewHeaders().Parse([]byte("X-Bad: a\x00b\r\n"))
+	assert.ErrorIs(t, err, ERROR_INVALID_FIELD_VALUE)
+
+	// Test: A bare CR right before the CRLF isn't trimmed away
+	_, _, err = NewHeaders().Parse([]byte("X-Bad: a\r\r\n"))
+	assert.ErrorIs(t, err, ERROR_INVALID_FIELD_VALUE)
+
+	// Test: HTAB is allowed inside a value and trimmed around it, like SP
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Tab: \ta\tb \t\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\tb", headers.Get("X-Tab"))
+
+	// Test: Obsolete line folding is rejected, not parsed as a new field
+	headers = NewHeaders()
+	data = []byte("X-Long: first\r\n second\r\n\tthird\r\n\r\n")
+	n, _, err = headers.Parse(data)
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n:])
+	assert.Equal(t, ERROR_OBS_FOLD, err)
+	_, _, err = headers.Parse([]byte("\tthird\r\n"))
+	assert.Equal(t, ERROR_OBS_FOLD, err)
+
+	// Test: Valid 2 headers with existing headers
+	headers = map[string][]string{"Host": {"localhost:42069"}}
+	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, 25, n)
+	assert.False(t, done)
+	n2, done, err := headers.Parse(data[n:])
+	require.NoError(t, err)
+	assert.Equal(t, 13, n2)
+	assert.False(t, done)
+	assert.Equal(t, "localhost:42069", headers.Get("Host"))
+	assert.Equal(t, "curl/7.81.0", headers.Get("User-Agent"))
+	assert.Equal(t, "*/*", headers.Get("Accept"))
+
+	// Test: Valid done
+	n, done, err = headers.Parse([]byte("\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, done)
+
+	// Test: Incomplete header line
+	headers = NewHeaders()
+	n, done, err = headers.Parse([]byte("Host: localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Repeated header names keep each value
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Forwarded-For: 10.0.0.1\r\n"))
+	require.NoError(t, err)
+	_, _, err = headers.Parse([]byte("X-Forwarded-For: 10.0.0.2\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1, 10.0.0.2", headers.Get("X-Forwarded-For"))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, headers.Values("X-Forwarded-For"))
+	assert.Nil(t, headers.Values("Missing"))
+
+	// Test: A value with commas of its own isn't split
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("If-Modified-Since: Sun, 06 Nov 1994 08:49:37 GMT\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Sun, 06 Nov 1994 08:49:37 GMT"}, headers.Values("If-Modified-Since"))
+}
+
+func TestHeadersList(t *testing.T) {
+	// Test: Elements are split across commas and field lines
+	headers := NewHeaders()
+	headers.Add("Connection", "close, X-Debug")
+	headers.Add("Connection", " ,X-Trace")
+	assert.Equal(t, []string{"close", "X-Debug", "X-Trace"}, headers.List("Connection"))
+
+	// Test: Commas inside quoted strings don't split
+	headers.Set("Accept", `text/plain; charset="a,b", text/html`)
+	assert.Equal(t, []string{`text/plain; charset="a,b"`, "text/html"}, headers.List("Accept"))
+
+	// Test: Missing header
+	assert.Nil(t, headers.List("Vary"))
+}
+
+func TestHeadersCaseInsensitive(t *testing.T) {
+	// Test: Parsed names are canonicalized
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("content-LENGTH: 42\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "42", headers.Get("Content-Length"))
+	assert.Equal(t, "42", headers.Get("content-length"))
+
+	// Test: Repeated names in different cases are kept under one name
+	_, _, err = headers.Parse([]byte("X-Forwarded-For: 10.0.0.1\r\n"))
+	require.NoError(t, err)
+	headers.Add("x-forwarded-for", "10.0.0.2")
+	assert.Equal(t, "10.0.0.1, 10.0.0.2", headers.Get("X-FORWARDED-FOR"))
+
+	// Test: Set replaces and Delete removes
+	headers.Set("CONTENT-length", "7")
+	assert.Equal(t, "7", headers.Get("Content-Length"))
+	headers.Delete("content-length")
+	assert.Equal(t, "", headers.Get("Content-Length"))
+	_, ok := headers["Content-Length"]
+	assert.False(t, ok)
+
+	// Test: Canonical form
+	assert.Equal(t, "Www-Authenticate", CanonicalName("WWW-AUTHENTICATE"))
+	assert.Equal(t, "X-Request-Id", CanonicalName("x-request-id"))
+}
+
+func TestRemoveHopByHop(t *testing.T) {
+	// Test: Standard and Connection-listed fields are removed, the rest kept
+	h := Headers{
+		"Connection":        {"close, x-debug"},
+		"Keep-Alive":        {"timeout=5"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"websocket"},
+		"X-Debug":           {"1"},
+		"Content-Type":      {"text/plain"},
+		"Host":              {"localhost"},
+	}
+	RemoveHopByHop(h)
+	assert.Equal(t, Headers{"Content-Type": {"text/plain"}, "Host": {"localhost"}}, h)
+
+	// Test: Nothing to remove
+	h = Headers{"Host": {"localhost"}}
+	RemoveHopByHop(h)
+	assert.Equal(t, Headers{"Host": {"localhost"}}, h)
+}
+
+func TestLineEnd(t *testing.T) {
+	// Test: Strict mode only ends lines at \r\n
+	idx, sepLen, err := LineEnd([]byte("Host: a\nB: c\r\n"), false)
+	require.NoError(t, err)
+	assert.Equal(t, 12, idx)
+	assert.Equal(t, 2, sepLen)
+
+	// Test: Lenient mode accepts a bare LF
+	idx, sepLen, err = LineEnd([]byte("Host: a\nB: c\r\n"), true)
+	require.NoError(t, err)
+	assert.Equal(t, 7, idx)
+	assert.Equal(t, 1, sepLen)
+
+	// Test: Lenient mode with \r\n
+	idx, sepLen, err = LineEnd([]byte("Host: a\r\n"), true)
+	require.NoError(t, err)
+	assert.Equal(t, 7, idx)
+	assert.Equal(t, 2, sepLen)
+
+	// Test: Trailing CR may still be followed by LF
+	idx, _, err = LineEnd([]byte("Host: a\r"), true)
+	require.NoError(t, err)
+	assert.Equal(t, -1, idx)
+
+	// Test: Bare CR is rejected
+	_, _, err = LineEnd([]byte("Host: a\rb"), true)
+	assert.Equal(t, ERROR_BARE_CR, err)
+
+	// Test: Parsing headers with bare LF
+	headers := NewHeaders()
+	n, done, err := headers.ParseLine([]byte("Host: localhost\n\n"), true)
+	require.NoError(t, err)
+	assert.Equal(t, 16, n)
+	assert.False(t, done)
+	assert.Equal(t, "localhost", headers.Get("Host"))
+}
+
+func TestWithCase(t *testing.T) {
+	// Test: Names are respelled, others stay canonical and h is untouched
+	h := Headers{"X-Custom-Header": {"1"}, "Host": {"localhost"}}
+	out := h.WithCase(map[string]string{"X-Custom-Header": "x-custom-HEADER"})
+	assert.Equal(t, Headers{"x-custom-HEADER": {"1"}, "Host": {"localhost"}}, out)
+	assert.Equal(t, "1", h.Get("X-Custom-Header"))
 }
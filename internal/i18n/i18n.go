@@ -0,0 +1,172 @@
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+)
+
+// LanguageRange is a single entry from an Accept-Language header
+// Tag: the language range as sent by the client (e.g. "en-US", "fr", "*")
+// Q: the quality value between 0 and 1 (defaults to 1 when missing)
+type LanguageRange struct {
+	Tag string
+	Q   float64
+}
+
+// ParseAcceptLanguage parses an Accept-Language header value into language ranges
+// sorted by descending quality. Ranges with the same quality keep the order
+// they were sent in. Malformed entries are skipped rather than failing the whole header.
+// Example: "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5" → [fr-CH 1] [fr 0.9] [en 0.8] [* 0.5]
+func ParseAcceptLanguage(value string) []LanguageRange {
+	ranges := []LanguageRange{}
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		// Split the tag from its parameters
+		// Example: "en;q=0.8" → ["en", "q=0.8"]
+		params := strings.Split(part, ";")
+		tag := strings.TrimSpace(params[0])
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		valid := true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") && !strings.HasPrefix(param, "Q=") {
+				continue
+			}
+
+			parsed, ok := parseQValue(param[2:])
+			if !ok {
+				valid = false
+				break
+			}
+			q = parsed
+		}
+		if !valid {
+			continue
+		}
+
+		ranges = append(ranges, LanguageRange{Tag: tag, Q: q})
+	}
+
+	// Highest quality first, stable so equal q-values keep the client's order
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Q > ranges[j].Q
+	})
+
+	return ranges
+}
+
+// parseQValue parses a weight by the qvalue grammar of RFC 9110 section 12.4.2:
+// "0" or "1", optionally followed by "." and up to three digits, which must
+// be zeros after a "1". ParseFloat alone would also take "NaN", "Inf" or "1e0".
+// Example: "0.75" → 0.75, true; "1.000" → 1, true; "0.1234" → 0, false
+func parseQValue(s string) (float64, bool) {
+	if s == "" || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+
+	if len(s) > 1 {
+		fraction, ok := strings.CutPrefix(s[1:], ".")
+		if !ok || len(fraction) > 3 {
+			return 0, false
+		}
+		for _, c := range fraction {
+			if c < '0' || c > '9' || (s[0] == '1' && c != '0') {
+				return 0, false
+			}
+		}
+	}
+
+	q, err := strconv.ParseFloat(s, 64)
+	return q, err == nil
+}
+
+// Negotiate picks the best locale from supported for the given Accept-Language value.
+// Matching is case-insensitive and a range matches a locale when it is equal to it
+// or a prefix of it ending at a "-" (so "en" matches "en-GB"). A locale offered
+// with q=0 is never chosen. Returns the first supported locale when nothing
+// matches, or "" when supported is empty.
+func Negotiate(acceptLanguage string, supported []string) string {
+	if len(supported) == 0 {
+		return ""
+	}
+
+	ranges := ParseAcceptLanguage(acceptLanguage)
+
+	// Locales the client explicitly refused with q=0
+	// Collected up front because "*" may sort ahead of the refusal
+	refused := map[string]bool{}
+	for _, r := range ranges {
+		if r.Q != 0 || r.Tag == "*" {
+			continue
+		}
+		for _, locale := range supported {
+			if matches(r.Tag, locale) {
+				refused[locale] = true
+			}
+		}
+	}
+
+	for _, r := range ranges {
+		if r.Q == 0 {
+			continue
+		}
+
+		for _, locale := range supported {
+			if refused[locale] {
+				continue
+			}
+			if matches(r.Tag, locale) {
+				return locale
+			}
+		}
+	}
+
+	for _, locale := range supported {
+		if !refused[locale] {
+			return locale
+		}
+	}
+	return supported[0]
+}
+
+// matches reports whether the language range covers the locale
+func matches(tag, locale string) bool {
+	if tag == "*" {
+		return true
+	}
+
+	tag = strings.ToLower(tag)
+	locale = strings.ToLower(locale)
+
+	return tag == locale || strings.HasPrefix(locale, tag+"-")
+}
+
+// Vary adds Accept-Language to the Vary header so caches store
+// one copy of the response per negotiated language
+func Vary(h headers.Headers) {
+	existing := h.Get("Vary")
+	if existing == "" {
+		h.Set("Vary", "Accept-Language")
+		return
+	}
+
+	for _, field := range h.List("Vary") {
+		if field == "*" || strings.EqualFold(field, "Accept-Language") {
+			return
+		}
+	}
+
+	h.Set("Vary", existing+", Accept-Language")
+}
@@ -0,0 +1,68 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	// Test: Ranges sorted by quality
+	ranges := ParseAcceptLanguage("en;q=0.8, fr-CH, *;q=0.5, fr;q=0.9")
+	require.Len(t, ranges, 4)
+	assert.Equal(t, LanguageRange{Tag: "fr-CH", Q: 1}, ranges[0])
+	assert.Equal(t, LanguageRange{Tag: "fr", Q: 0.9}, ranges[1])
+	assert.Equal(t, LanguageRange{Tag: "en", Q: 0.8}, ranges[2])
+	assert.Equal(t, LanguageRange{Tag: "*", Q: 0.5}, ranges[3])
+
+	// Test: Malformed q-values are skipped
+	ranges = ParseAcceptLanguage("de;q=abc, en;q=2, es")
+	require.Len(t, ranges, 1)
+	assert.Equal(t, "es", ranges[0].Tag)
+
+	// Test: Only the RFC 9110 qvalue grammar is accepted
+	for _, q := range []string{"NaN", "Inf", "1e0", "0.1234", "1.001", "+0.5", ".5", "01", "0.5x"} {
+		ranges = ParseAcceptLanguage("de;q=" + q + ", es")
+		assert.Equal(t, []LanguageRange{{Tag: "es", Q: 1}}, ranges, q)
+	}
+	ranges = ParseAcceptLanguage("a;q=0, b;q=0.5, c;q=0.125, d;q=1.000, e;q=1, f;q=1., g;q=0.")
+	assert.Equal(t, []LanguageRange{{"d", 1}, {"e", 1}, {"f", 1}, {"b", 0.5}, {"c", 0.125}, {"a", 0}, {"g", 0}}, ranges)
+
+	// Test: Empty header
+	assert.Empty(t, ParseAcceptLanguage(""))
+}
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{"en-US", "fr-FR", "de"}
+
+	// Test: Exact match
+	assert.Equal(t, "de", Negotiate("de", supported))
+
+	// Test: Prefix match and case-insensitivity
+	assert.Equal(t, "fr-FR", Negotiate("FR;q=0.9, es", supported))
+
+	// Test: Highest quality wins
+	assert.Equal(t, "en-US", Negotiate("de;q=0.5, en;q=0.7", supported))
+
+	// Test: Wildcard skips refused locales
+	assert.Equal(t, "fr-FR", Negotiate("en;q=0, *", supported))
+
+	// Test: No match falls back to the first supported locale
+	assert.Equal(t, "en-US", Negotiate("ja", supported))
+	assert.Equal(t, "", Negotiate("en", nil))
+}
+
+func TestVary(t *testing.T) {
+	// Test: Adds the header when missing
+	h := headers.NewHeaders()
+	Vary(h)
+	assert.Equal(t, "Accept-Language", h.Get("Vary"))
+
+	// Test: Appends to an existing value once
+	h = headers.Headers{"Vary": {"Accept-Encoding"}}
+	Vary(h)
+	Vary(h)
+	assert.Equal(t, "Accept-Encoding, Accept-Language", h.Get("Vary"))
+}
@@ -0,0 +1,82 @@
+package memconn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ERROR_LISTENER_CLOSED is returned by Accept and Dial once the listener is closed
+var ERROR_LISTENER_CLOSED = fmt.Errorf("memconn: listener closed")
+
+// addr is the net.Addr reported by in-memory listeners and connections
+type addr struct{}
+
+func (addr) Network() string { return "memconn" }
+func (addr) String() string  { return "memconn" }
+
+// Listener is an in-memory net.Listener. Every Dial creates a synchronous
+// net.Pipe and hands the server end to Accept, so client/server tests
+// run without opening real sockets or fighting over ports.
+type Listener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener creates an in-memory listener ready to Accept and Dial
+func NewListener() *Listener {
+	return &Listener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept waits for the next Dial and returns the server end of the pipe
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ERROR_LISTENER_CLOSED
+	}
+}
+
+// Close stops the listener. Pending and future Accept and Dial calls fail.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the listener's placeholder address
+func (l *Listener) Addr() net.Addr {
+	return addr{}
+}
+
+// Dial connects to the listener and returns the client end of the pipe.
+// It blocks until the server side calls Accept.
+func (l *Listener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background(), "memconn", "memconn")
+}
+
+// DialContext matches the signature of net.Dialer.DialContext so the listener
+// can stand in for a real dialer. The network and address are ignored.
+func (l *Listener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	server, client := net.Pipe()
+
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		server.Close()
+		client.Close()
+		return nil, ERROR_LISTENER_CLOSED
+	case <-ctx.Done():
+		server.Close()
+		client.Close()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,52 @@
+package memconn
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerDial(t *testing.T) {
+	l := NewListener()
+
+	// Test: Server parses a request sent through the in-memory pipe
+	parsed := make(chan *request.Request)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(parsed)
+			return
+		}
+		defer conn.Close()
+		r, _ := request.RequestFromReader(conn)
+		parsed <- r
+	}()
+
+	client, err := l.Dial()
+	require.NoError(t, err)
+	_, err = io.WriteString(client, "GET /coffee HTTP/1.1\r\nHost: memconn\r\n\r\n")
+	require.NoError(t, err)
+
+	r := <-parsed
+	require.NotNil(t, r)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+	client.Close()
+
+	// Test: Dial honours context cancellation when nobody accepts
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.DialContext(ctx, "memconn", "memconn")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: Accept and Dial fail after Close
+	require.NoError(t, l.Close())
+	_, err = l.Accept()
+	assert.Equal(t, ERROR_LISTENER_CLOSED, err)
+	_, err = l.Dial()
+	assert.Equal(t, ERROR_LISTENER_CLOSED, err)
+}
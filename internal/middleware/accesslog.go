@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/clock"
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+	"github.com/jrooke/httpfromtcp/internal/server"
+)
+
+// Entry is what AccessLog records about one request
+type Entry struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	Target     string
+	Proto      string
+
+	// Status is 0 if the handler never wrote a status line, or 500 if it
+	// panicked before writing one, which is what the server then answers
+	Status response.StatusCode
+
+	// Bytes counts the response body, not the status line or headers
+	Bytes    int64
+	Duration time.Duration
+}
+
+// LogFormat turns an Entry into one log line, without the trailing newline
+type LogFormat func(e Entry) []byte
+
+// CommonLogFormat is the Common Log Format web servers have long used
+// Example: `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.1" 200 2326`
+// It has no field for the latency, use JSONLogFormat or a custom format for that.
+func CommonLogFormat(e Entry) []byte {
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil || host == "" {
+		host = "-"
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Appendf(nil, "%s - - [%s] \"%s %s %s\" %d %s",
+		host, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Target, e.Proto, e.Status, size)
+}
+
+// JSONLogFormat writes the entry as a JSON object, with the latency in milliseconds
+// Example: {"time":"2000-10-10T13:55:36Z","remote_addr":"127.0.0.1:5000","method":"GET",...,"duration_ms":1.5}
+func JSONLogFormat(e Entry) []byte {
+	line, _ := json.Marshal(struct {
+		Time       time.Time `json:"time"`
+		RemoteAddr string    `json:"remote_addr"`
+		Method     string    `json:"method"`
+		Target     string    `json:"target"`
+		Proto      string    `json:"proto"`
+		Status     int       `json:"status"`
+		Bytes      int64     `json:"bytes"`
+		DurationMS float64   `json:"duration_ms"`
+	}{e.Time, e.RemoteAddr, e.Method, e.Target, e.Proto, int(e.Status), e.Bytes, float64(e.Duration) / float64(time.Millisecond)})
+	return line
+}
+
+// AccessLogOptions pick where AccessLog writes and how
+// The zero value writes CommonLogFormat lines to os.Stderr.
+type AccessLogOptions struct {
+	// Output receives one line per request, os.Stderr when nil.
+	// Ignored when Logger is set.
+	Output io.Writer
+
+	// Format renders each line for Output, CommonLogFormat when nil
+	Format LogFormat
+
+	// Logger, when set, receives each request as a structured slog record
+	// ("request" at Info level) instead of a line on Output
+	Logger *slog.Logger
+
+	// Clock stamps and times requests, clock.System when nil
+	Clock clock.Clock
+}
+
+// AccessLog logs the method, target, status, body bytes, latency and remote
+// address of every request once its handler returns
+func AccessLog(options AccessLogOptions) Middleware {
+	if options.Output == nil {
+		options.Output = os.Stderr
+	}
+	if options.Format == nil {
+		options.Format = CommonLogFormat
+	}
+	if options.Clock == nil {
+		options.Clock = clock.System
+	}
+
+	// Lines from concurrent requests must not interleave
+	var mu sync.Mutex
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := options.Clock.Now()
+
+			// panicking is still true in the deferred log if next panicked.
+			// Checking a flag rather than recovering leaves the panic, and
+			// the stack the server reports with it, untouched.
+			panicking := true
+
+			// Log even when the handler panics, the server still answers it
+			defer func() {
+				e := Entry{
+					Time:     start,
+					Method:   req.RequestLine.Method,
+					Target:   req.RequestLine.RequestTarget,
+					Proto:    "HTTP/" + req.RequestLine.HttpVersion,
+					Status:   w.Status(),
+					Bytes:    w.BodyBytes(),
+					Duration: options.Clock.Now().Sub(start),
+				}
+				if panicking && e.Status == 0 {
+					e.Status = response.StatusInternalServerError
+				}
+				if req.RemoteAddr != nil {
+					e.RemoteAddr = req.RemoteAddr.String()
+				}
+
+				if options.Logger != nil {
+					options.Logger.Info("request",
+						"remote_addr", e.RemoteAddr,
+						"method", e.Method,
+						"target", e.Target,
+						"proto", e.Proto,
+						"status", int(e.Status),
+						"bytes", e.Bytes,
+						"duration", e.Duration,
+					)
+					return
+				}
+
+				line := append(options.Format(e), '\n')
+				mu.Lock()
+				defer mu.Unlock()
+				options.Output.Write(line)
+			}()
+
+			next(w, req)
+			panicking = false
+		}
+	}
+}
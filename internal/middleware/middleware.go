@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/jrooke/httpfromtcp/internal/server"
+)
+
+// Middleware wraps a handler to add behaviour around every request,
+// e.g. logging it
+type Middleware func(next server.Handler) server.Handler
+
+// Chain wraps handler in the given middleware, the first one outermost
+// Example: Chain(h, first, second) runs first, then second, then h
+func Chain(handler server.Handler, middleware ...Middleware) server.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
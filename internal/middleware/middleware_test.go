@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/clock"
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+	"github.com/jrooke/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// run passes one raw request through handler, as if it came from 192.0.2.1:5000
+func run(t *testing.T, handler server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}
+	buf := &bytes.Buffer{}
+	handler(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+// hello answers "hello" after advancing c by 1.5ms
+func hello(c *clock.Fake) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		c.Advance(1500 * time.Microsecond)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(5))
+		w.WriteBody([]byte("hello"))
+	}
+}
+
+func TestChain(t *testing.T) {
+	// Test: Middleware runs outermost first
+	order := []string{}
+	mark := func(name string) Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name)
+				next(w, req)
+			}
+		}
+	}
+	h := Chain(func(*response.Writer, *request.Request) { order = append(order, "handler") }, mark("first"), mark("second"))
+	run(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestAccessLog(t *testing.T) {
+	start := time.Date(2000, 10, 10, 13, 55, 36, 0, time.UTC)
+
+	// Test: Common Log Format by default
+	c := clock.NewFake(start)
+	out := &bytes.Buffer{}
+	h := AccessLog(AccessLogOptions{Output: out, Clock: c})(hello(c))
+	run(t, h, "GET /a.gif?x=1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "192.0.2.1 - - [10/Oct/2000:13:55:36 +0000] \"GET /a.gif?x=1 HTTP/1.1\" 200 5\n", out.String())
+
+	// Test: Nothing written is logged as status 0 and "-" bytes
+	out.Reset()
+	h = AccessLog(AccessLogOptions{Output: out, Clock: c})(func(*response.Writer, *request.Request) {})
+	run(t, h, "GET / HTTP/1.0\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\"GET / HTTP/1.0\" 0 -\n"), out.String())
+
+	// Test: JSON lines include the latency
+	c = clock.NewFake(start)
+	out.Reset()
+	h = AccessLog(AccessLogOptions{Output: out, Format: JSONLogFormat, Clock: c})(hello(c))
+	run(t, h, "POST /upload HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	entry := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, map[string]any{
+		"time":        "2000-10-10T13:55:36Z",
+		"remote_addr": "192.0.2.1:5000",
+		"method":      "POST",
+		"target":      "/upload",
+		"proto":       "HTTP/1.1",
+		"status":      float64(200),
+		"bytes":       float64(5),
+		"duration_ms": 1.5,
+	}, entry)
+
+	// Test: A slog.Logger gets structured records instead
+	out.Reset()
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	h = AccessLog(AccessLogOptions{Logger: logger, Clock: c})(hello(c))
+	run(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "level=INFO msg=request remote_addr=192.0.2.1:5000 method=GET target=/ proto=HTTP/1.1 status=200 bytes=5 duration=1.5ms\n", out.String())
+
+	// Test: Panicking handlers are still logged, as the 500 the server answers,
+	// and the panic still reaches the server
+	out.Reset()
+	h = AccessLog(AccessLogOptions{Output: out, Clock: c})(func(*response.Writer, *request.Request) { panic("boom") })
+	assert.PanicsWithValue(t, "boom", func() { run(t, h, "GET /boom HTTP/1.1\r\nHost: localhost\r\n\r\n") })
+	assert.Contains(t, out.String(), "\"GET /boom HTTP/1.1\" 500 -")
+}
@@ -0,0 +1,258 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Errors returned while reading a PROXY protocol preamble
+var ERROR_MISSING_PROXY_HEADER = fmt.Errorf("proxyproto: missing PROXY header from trusted source")
+var ERROR_MALFORMED_PROXY_HEADER = fmt.Errorf("proxyproto: malformed PROXY header")
+
+// v1 headers are a single text line of at most 107 bytes (including \r\n)
+const maxV1HeaderBytes = 107
+
+var v1Prefix = []byte("PROXY ")
+
+// v2 headers start with this fixed 12 byte signature
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// DefaultHeaderTimeout is how long Accept waits for a PROXY header when
+// Listener.HeaderTimeout is zero
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener wraps a net.Listener and strips HAProxy PROXY protocol
+// preambles (v1 text or v2 binary) from connections coming from trusted sources.
+// Connections from untrusted sources are passed through untouched.
+type Listener struct {
+	net.Listener
+
+	// Trusted reports whether the peer (usually the load balancer) is allowed
+	// to send a PROXY header. A nil Trusted trusts no peer, so the header
+	// is never read: a client must not be able to claim any address it likes.
+	Trusted func(addr net.Addr) bool
+
+	// HeaderTimeout bounds how long Accept waits for a trusted peer's
+	// PROXY header, DefaultHeaderTimeout when zero
+	HeaderTimeout time.Duration
+}
+
+// NewListener wraps inner so accepted connections from trusted peers
+// report the client address carried in their PROXY header.
+// trusted is required, nil trusts no peer (see Listener.Trusted).
+func NewListener(inner net.Listener, trusted func(addr net.Addr) bool) *Listener {
+	return &Listener{
+		Listener: inner,
+		Trusted:  trusted,
+	}
+}
+
+// Accept waits for the next connection. A trusted peer's PROXY header is read
+// before Accept returns, so RemoteAddr and LocalAddr never touch the network.
+// That blocks the accept loop, but only for trusted peers and for at most
+// HeaderTimeout. A missing or malformed header doesn't fail Accept: the
+// connection is returned and its first Read reports the error.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Trusted == nil || !l.Trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultHeaderTimeout
+	}
+
+	c := &Conn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	c.readHeader()
+	conn.SetReadDeadline(time.Time{})
+	return c, nil
+}
+
+// Conn is a connection from a trusted peer that starts with a PROXY header
+type Conn struct {
+	net.Conn
+
+	reader *bufio.Reader
+
+	// err is why the PROXY header couldn't be read, returned by every Read
+	err error
+
+	// Source and destination as announced by the proxy
+	// nil when the header was LOCAL/UNKNOWN, in which case the socket addresses are used
+	source      net.Addr
+	destination net.Addr
+}
+
+// Read reads application data following the PROXY header
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the real client address from the PROXY header,
+// falling back to the socket's peer address
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the original destination address from the PROXY header,
+// falling back to the socket's local address
+func (c *Conn) LocalAddr() net.Addr {
+	if c.destination != nil {
+		return c.destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader detects the protocol version and parses the preamble
+func (c *Conn) readHeader() {
+	peek, err := c.reader.Peek(len(v1Prefix))
+	if err != nil {
+		c.err = ERROR_MISSING_PROXY_HEADER
+		return
+	}
+
+	if bytes.Equal(peek, v1Prefix) {
+		c.source, c.destination, c.err = readV1(c.reader)
+		return
+	}
+
+	peek, err = c.reader.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(peek, v2Signature) {
+		c.source, c.destination, c.err = readV2(c.reader)
+		return
+	}
+
+	c.err = ERROR_MISSING_PROXY_HEADER
+}
+
+// readV1 parses a text header
+// Example: "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	line := []byte{}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+		}
+		line = append(line, b)
+
+		if len(line) > maxV1HeaderBytes {
+			return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+		}
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+
+	fields := bytes.Split(line[:len(line)-2], []byte(" "))
+
+	// "PROXY UNKNOWN ..." means the proxy couldn't tell, keep the socket addresses
+	if len(fields) >= 2 && string(fields[1]) == "UNKNOWN" {
+		return nil, nil, nil
+	}
+
+	if len(fields) != 6 {
+		return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+
+	family := string(fields[1])
+	if family != "TCP4" && family != "TCP6" {
+		return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4], family)
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, dst, nil
+}
+
+// parseV1Addr combines a textual IP and port into a TCP address
+func parseV1Addr(ip, port []byte, family string) (net.Addr, error) {
+	parsedIP := net.ParseIP(string(ip))
+	if parsedIP == nil {
+		return nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+	if (family == "TCP4") != (parsedIP.To4() != nil) {
+		return nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+
+	parsedPort, err := strconv.ParseUint(string(port), 10, 16)
+	if err != nil {
+		return nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+
+	return &net.TCPAddr{IP: parsedIP, Port: int(parsedPort)}, nil
+}
+
+// readV2 parses a binary header
+// Layout: signature (12) | version+command (1) | family+protocol (1) | length (2) | addresses + TLVs (length)
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+
+	version := header[12] >> 4
+	command := header[12] & 0x0F
+	if version != 2 || command > 1 {
+		return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+
+	length := binary.BigEndian.Uint16(header[14:16])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+	}
+
+	// LOCAL command: health check from the proxy itself, keep the socket addresses
+	if command == 0 {
+		return nil, nil, nil
+	}
+
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		return src, dst, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, nil, ERROR_MALFORMED_PROXY_HEADER
+		}
+		src := &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		dst := &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		return src, dst, nil
+	}
+
+	// Unsupported families (UDP, unix sockets) are accepted but ignored
+	return nil, nil, nil
+}
@@ -0,0 +1,115 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptWith starts a Listener, writes data from a client and returns the accepted connection
+func acceptWith(t *testing.T, trusted func(net.Addr) bool, data []byte) net.Conn {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { inner.Close() })
+
+	l := NewListener(inner, trusted)
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	_, err = client.Write(data)
+	require.NoError(t, err)
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// trustAll trusts every peer, the test clients all connect from 127.0.0.1
+func trustAll(net.Addr) bool { return true }
+
+func TestProxyProtocol(t *testing.T) {
+	// Test: v1 TCP4 header
+	conn := acceptWith(t, trustAll, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	assert.Equal(t, "203.0.113.7:56324", conn.RemoteAddr().String())
+	assert.Equal(t, "10.0.0.1:443", conn.LocalAddr().String())
+	buf := make([]byte, 16)
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(buf))
+
+	// Test: v1 UNKNOWN keeps the socket address
+	conn = acceptWith(t, trustAll, []byte("PROXY UNKNOWN\r\n"))
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+
+	// Test: v2 TCP over IPv4 header
+	v2 := append([]byte{}, v2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 198, 51, 100, 2, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 40000)
+	v2 = binary.BigEndian.AppendUint16(v2, 80)
+	v2 = append(v2, []byte("GET")...)
+	conn = acceptWith(t, trustAll, v2)
+	assert.Equal(t, "198.51.100.2:40000", conn.RemoteAddr().String())
+	buf = make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", string(buf))
+
+	// Test: Trusted peer without a header is rejected
+	conn = acceptWith(t, trustAll, []byte("GET / HTTP/1.1\r\n"))
+	_, err = conn.Read(buf)
+	assert.Equal(t, ERROR_MISSING_PROXY_HEADER, err)
+
+	// Test: Malformed v1 header
+	conn = acceptWith(t, trustAll, []byte("PROXY TCP4 not-an-ip 10.0.0.1 1 2\r\n"))
+	_, err = conn.Read(buf)
+	assert.Equal(t, ERROR_MALFORMED_PROXY_HEADER, err)
+
+	// Test: Untrusted peer is passed through untouched
+	untrusted := func(net.Addr) bool { return false }
+	conn = acceptWith(t, untrusted, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n"))
+	buf = make([]byte, 6)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY ", string(buf))
+
+	// Test: A nil Trusted trusts no peer
+	conn = acceptWith(t, nil, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n"))
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY ", string(buf))
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inner.Close()
+	l := NewListener(inner, trustAll)
+	l.HeaderTimeout = 50 * time.Millisecond
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("PROXY TCP4 203.0.113.7"))
+	require.NoError(t, err)
+
+	// Test: Accept gives up on a header that never finishes, and the
+	// addresses are the socket's without waiting on the network
+	start := time.Now()
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, ERROR_MALFORMED_PROXY_HEADER, err)
+}
@@ -0,0 +1,37 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ReadRequests parses every request in r one after another, e.g. the client
+// side of a captured TCP stream, and calls fn with each request and the byte
+// offset it starts at. A single Reader (and its buffer) is reused for the
+// whole stream. It stops at the first error from fn, which is returned as is,
+// or from parsing, which is wrapped with the offset of the bad request.
+// Reaching the end of r between requests is not an error.
+func ReadRequests(r io.Reader, fn func(req *Request, offset int64) error) error {
+	reader := NewReader(r)
+	for {
+		offset := reader.Offset()
+		req, err := reader.ReadRequest()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("request at offset %d: %w", offset, err)
+		}
+
+		if err := fn(req, offset); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadRequestsAt is ReadRequests over the first size bytes of ra, such as an
+// *os.File or a memory-mapped capture, without moving any shared file offset
+func ReadRequestsAt(ra io.ReaderAt, size int64, fn func(req *Request, offset int64) error) error {
+	return ReadRequests(io.NewSectionReader(ra, 0, size), fn)
+}
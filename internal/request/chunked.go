@@ -0,0 +1,231 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+)
+
+// Errors returned while decoding a chunked body
+var ERROR_UNSUPPORTED_TRANSFER_ENCODING = fmt.Errorf("ERROR: Unsupported Transfer-Encoding")
+var ERROR_MALFORMED_CHUNK = fmt.Errorf("ERROR: Malformed Chunk")
+var ERROR_UNANNOUNCED_TRAILER = fmt.Errorf("ERROR: Trailer Field Not Announced")
+
+// ERROR_FORBIDDEN_TRAILER is returned for a trailer field that can't be sent
+// as a trailer, announced or not (see forbiddenTrailers)
+var ERROR_FORBIDDEN_TRAILER = fmt.Errorf("ERROR: Field Not Allowed In Trailers")
+
+// isChunked reports whether the body uses the chunked transfer coding.
+// Per RFC 9112 chunked must be the final coding; we don't support
+// any other codings (gzip, deflate, ...) so anything else is an error.
+func isChunked(h headers.Headers) (bool, error) {
+	if !h.Has("Transfer-Encoding") {
+		return false, nil
+	}
+
+	codings := h.List("Transfer-Encoding")
+	if len(codings) != 1 || !strings.EqualFold(codings[0], "chunked") {
+		return false, ERROR_UNSUPPORTED_TRANSFER_ENCODING
+	}
+	return true, nil
+}
+
+// parseChunked advances the chunked body states by one step
+// Input (data []byte): unparsed bytes starting at the current chunk position
+// Returns: (bytes consumed, error); 0 bytes consumed means more data is needed
+//
+// Wire format:
+//
+//	<size in hex>[;extensions]\r\n
+//	<size bytes of data>\r\n
+//	... repeated ...
+//	0\r\n
+//	[trailer fields]\r\n
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.state {
+	case StateChunkSize:
+		if err := r.limits.checkChunkSizeLine(data); err != nil {
+			return 0, err
+		}
+
+		idx, sepLen, err := headers.LineEnd(data, r.options.AllowBareLF)
+		if err != nil {
+			return 0, err
+		}
+		if idx == -1 {
+			return 0, nil
+		}
+
+		size, err := parseChunkSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+
+		// The zero-length chunk ends the body, trailers may follow
+		if size == 0 {
+			r.state = StateTrailers
+		} else {
+			r.chunkRemaining = size
+			r.state = StateChunkData
+		}
+		return idx + sepLen, nil
+
+	case StateChunkData:
+		n := min(r.chunkRemaining, len(data))
+		if n == 0 {
+			return 0, nil
+		}
+
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkRemaining -= n
+
+		if r.chunkRemaining == 0 {
+			r.state = StateChunkDataEnd
+		}
+		return n, nil
+
+	case StateChunkDataEnd:
+		// Chunk data must be followed by exactly \r\n (or \n when allowed)
+		idx, sepLen, err := headers.LineEnd(data, r.options.AllowBareLF)
+		if err != nil {
+			return 0, err
+		}
+		if idx == -1 {
+			if len(data) >= len(SEPARATOR) {
+				return 0, ERROR_MALFORMED_CHUNK
+			}
+			return 0, nil
+		}
+		if idx != 0 {
+			return 0, ERROR_MALFORMED_CHUNK
+		}
+
+		r.state = StateChunkSize
+		return sepLen, nil
+
+	case StateTrailers:
+		// Trailer fields use the same syntax as headers and end with an empty line
+		n, done, err := r.Trailers.ParseLine(data, r.options.AllowBareLF)
+		if err != nil {
+			return 0, err
+		}
+
+		if done {
+			r.state = StateDone
+			return n, nil
+		}
+
+		// The trailer section gets the header section's limits, checked as
+		// each line arrives so a client can't stream fields in forever
+		if n > 0 {
+			r.trailerBytes += n
+			r.trailerCount++
+
+			// The line parsed, so its name is everything before the colon
+			name := string(data[:bytes.IndexByte(data, ':')])
+			if err := checkTrailer(r.Headers, name); err != nil {
+				return 0, err
+			}
+		}
+		if err := r.limits.checkHeaders(r.trailerBytes, r.trailerCount, data[n:]); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+
+	return 0, nil
+}
+
+// parseChunkSize parses the hex size from a chunk-size line, ignoring chunk extensions
+// Example: "1a;name=value" → 26
+func parseChunkSize(line []byte) (int, error) {
+	if idx := bytes.IndexByte(line, ';'); idx != -1 {
+		line = line[:idx]
+	}
+	line = bytes.TrimRight(line, " \t")
+
+	if len(line) == 0 {
+		return 0, ERROR_MALFORMED_CHUNK
+	}
+
+	// ParseInt would accept a sign, so check for hex digits only
+	for _, c := range line {
+		if !isHex(c) {
+			return 0, ERROR_MALFORMED_CHUNK
+		}
+	}
+
+	size, err := strconv.ParseInt(string(line), 16, 32)
+	if err != nil {
+		return 0, ERROR_MALFORMED_CHUNK
+	}
+	return int(size), nil
+}
+
+// forbiddenTrailers lists the fields a sender must not put in trailers
+// (RFC 9110 section 6.5.1): those needed to frame or route the message, or
+// to decide how to process it before the body arrives. Honouring one found
+// after the body would let it contradict what the headers said.
+var forbiddenTrailers = map[string]bool{
+	// Message framing
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+
+	// Routing
+	"Host": true,
+
+	// Request modifiers and controls
+	"Cache-Control":       true,
+	"Expect":              true,
+	"Max-Forwards":        true,
+	"Pragma":              true,
+	"Range":               true,
+	"Te":                  true,
+	"If-Match":            true,
+	"If-None-Match":       true,
+	"If-Modified-Since":   true,
+	"If-Unmodified-Since": true,
+	"If-Range":            true,
+
+	// Authentication
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+
+	// Content processing
+	"Content-Encoding": true,
+	"Content-Type":     true,
+	"Content-Range":    true,
+
+	// Connection management
+	"Connection": true,
+	"Keep-Alive": true,
+	"Upgrade":    true,
+}
+
+// checkTrailer checks a trailer field name as soon as its line is parsed:
+// it must not be one forbidden in trailers, even if the Trailer header
+// announced it, and otherwise it must have been announced, as RFC 9112
+// section 7.1.2 expects
+func checkTrailer(h headers.Headers, name string) error {
+	name = headers.CanonicalName(name)
+	if forbiddenTrailers[name] {
+		return fmt.Errorf("%w: %s", ERROR_FORBIDDEN_TRAILER, name)
+	}
+
+	for _, announced := range h.List("Trailer") {
+		if headers.CanonicalName(announced) == name {
+			return nil
+		}
+	}
+	return ERROR_UNANNOUNCED_TRAILER
+}
+
+// isHex reports whether c is a hexadecimal digit
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,63 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Errors returned for a bad Host header (RFC 9112 section 3.2), answered with a 400
+var ERROR_MISSING_HOST = fmt.Errorf("ERROR: Missing Host Header")
+var ERROR_DUPLICATE_HOST = fmt.Errorf("ERROR: Multiple Host Headers")
+var ERROR_INVALID_HOST = fmt.Errorf("ERROR: Invalid Host Header")
+
+// checkHost validates the Host header once the headers are parsed.
+// More than one Host, or one that isn't host[:port], is always rejected since
+// servers and proxies picking different ones is a request smuggling vector.
+// A missing Host is rejected too, unless ParserOptions.AllowMissingHost is
+// set, but never for HTTP/1.0, which predates the header.
+func (r *Request) checkHost() error {
+	values := r.Headers.Values("Host")
+	switch {
+	case values == nil:
+		if !r.options.AllowMissingHost && !r.IsHTTP10() {
+			return ERROR_MISSING_HOST
+		}
+		return nil
+	case len(values) > 1:
+		return ERROR_DUPLICATE_HOST
+	case !validHost(values[0]):
+		return fmt.Errorf("%w: %q", ERROR_INVALID_HOST, values[0])
+	}
+	return nil
+}
+
+// validHost reports whether host only uses the characters of a host[:port]
+// authority (reg-name, IP literal in brackets, port). An empty Host is allowed.
+func validHost(host string) bool {
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if isUnreservedByte(c) || strings.IndexByte("!$&'()*+;=%:[]", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// isUnreservedByte reports whether c is a letter, digit or -._~
+func isUnreservedByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// Host returns the host the request is for (RFC 9112 section 3.2.2):
+// the authority of an absolute-form or authority-form target when there is
+// one, since it overrides the Host header, otherwise the Host header
+// Example: "GET http://example.com/ HTTP/1.1" with "Host: other" → "example.com"
+// A target that fails to parse (see URL) falls back to the Host header.
+func (r *Request) Host() string {
+	if u, err := r.URL(); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return r.Headers.Get("Host")
+}
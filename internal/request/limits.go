@@ -0,0 +1,111 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Errors returned when a request exceeds its Limits
+// ERROR_REQUEST_LINE_TOO_LONG and ERROR_TARGET_TOO_LONG map to 414 URI Too Long,
+// the header errors to 431 Request Header Fields Too Large
+var ERROR_REQUEST_LINE_TOO_LONG = fmt.Errorf("ERROR: Request Line Too Long")
+var ERROR_TARGET_TOO_LONG = fmt.Errorf("ERROR: Request Target Too Long")
+var ERROR_HEADERS_TOO_LARGE = fmt.Errorf("ERROR: Request Header Fields Too Large")
+var ERROR_TOO_MANY_HEADERS = fmt.Errorf("ERROR: Too Many Request Header Fields")
+var ERROR_CHUNK_SIZE_LINE_TOO_LONG = fmt.Errorf("ERROR: Chunk Size Line Too Long")
+
+// Limits bound how much of a request head the parser will buffer
+// A zero field means no limit for that check
+type Limits struct {
+	// MaxRequestLineBytes is the longest request line accepted, excluding \r\n
+	MaxRequestLineBytes int
+
+	// MaxTargetBytes is the longest request target (URL) accepted, for enforcing
+	// a URL-length policy (e.g. 2 KB) tighter than the request line limit
+	MaxTargetBytes int
+
+	// MaxHeaderBytes is the total size of all header lines, including their \r\n.
+	// The trailer section after a chunked body gets the same limit of its own.
+	MaxHeaderBytes int
+
+	// MaxHeaderCount is the number of header lines accepted, and likewise of trailer lines
+	MaxHeaderCount int
+
+	// MaxChunkSizeLineBytes is the longest chunk-size line (hex size plus any
+	// extensions, excluding \r\n) accepted in a chunked body
+	MaxChunkSizeLineBytes int
+}
+
+// DefaultLimits are used by RequestFromReader and NewReader
+var DefaultLimits = Limits{
+	MaxRequestLineBytes:   8 * 1024,
+	MaxHeaderBytes:        64 * 1024,
+	MaxHeaderCount:        100,
+	MaxChunkSizeLineBytes: 4 * 1024,
+}
+
+// checkRequestLine fails once the request line is known to be longer than allowed,
+// even if its \r\n hasn't arrived yet
+func (l Limits) checkRequestLine(data []byte) error {
+	if l.MaxRequestLineBytes == 0 {
+		return nil
+	}
+
+	if lineLength(data) > l.MaxRequestLineBytes {
+		return ERROR_REQUEST_LINE_TOO_LONG
+	}
+	return nil
+}
+
+// checkChunkSizeLine fails once the chunk-size line is known to be longer than allowed,
+// so a client can't make us buffer endless "extensions" while we look for \r\n
+func (l Limits) checkChunkSizeLine(data []byte) error {
+	if l.MaxChunkSizeLineBytes == 0 {
+		return nil
+	}
+
+	if lineLength(data) > l.MaxChunkSizeLineBytes {
+		return ERROR_CHUNK_SIZE_LINE_TOO_LONG
+	}
+	return nil
+}
+
+// checkTarget fails if the parsed request target is longer than allowed
+// The error says by how much so clients can tell what to shorten
+func (l Limits) checkTarget(target string) error {
+	if l.MaxTargetBytes == 0 || len(target) <= l.MaxTargetBytes {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes, the limit is %d bytes", ERROR_TARGET_TOO_LONG, len(target), l.MaxTargetBytes)
+}
+
+// checkHeaders fails once the headers parsed so far (headerBytes, headerCount),
+// plus any incomplete line still waiting in data, go over the limits
+func (l Limits) checkHeaders(headerBytes, headerCount int, data []byte) error {
+	if l.MaxHeaderCount > 0 && headerCount > l.MaxHeaderCount {
+		return ERROR_TOO_MANY_HEADERS
+	}
+
+	if l.MaxHeaderBytes == 0 {
+		return nil
+	}
+
+	if headerBytes+lineLength(data) > l.MaxHeaderBytes {
+		return ERROR_HEADERS_TOO_LARGE
+	}
+	return nil
+}
+
+// lineLength is the length of the first line in data without its terminator,
+// or all of data if the line isn't complete yet. Bare LF counts as a
+// terminator here so the limits measure the same thing in either parser mode.
+func lineLength(data []byte) int {
+	idx := bytes.IndexByte(data, '\n')
+	if idx == -1 {
+		return len(data)
+	}
+	if idx > 0 && data[idx-1] == '\r' {
+		return idx - 1
+	}
+	return idx
+}
@@ -0,0 +1,89 @@
+package request
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+)
+
+// ERROR_MALFORMED_METHOD is returned for a method that isn't a token,
+// or in strict mode isn't upper-case letters
+var ERROR_MALFORMED_METHOD = fmt.Errorf("ERROR: Malformed Method")
+
+// ERROR_UNSUPPORTED_METHOD is returned for a well-formed method missing from
+// ParserOptions.AllowedMethods. A server answers it with 501 Not Implemented.
+var ERROR_UNSUPPORTED_METHOD = fmt.Errorf("ERROR: Unsupported Method")
+
+// ParserOptions choose between a strict conformance parser and a forgiving one
+// for sloppy clients. The zero value is the default: strict about line endings,
+// Content-Length and Host, but accepting any method that is a valid token.
+type ParserOptions struct {
+	// StrictMethod only accepts methods made of upper-case letters (GET, POST, ...),
+	// rejecting e.g. "get" with ERROR_MALFORMED_METHOD
+	StrictMethod bool
+
+	// AllowedMethods, when not empty, is the only methods accepted (case-sensitive,
+	// e.g. []string{"GET", "HEAD", "POST"}). Others fail with ERROR_UNSUPPORTED_METHOD.
+	AllowedMethods []string
+
+	// AllowMissingHost accepts HTTP/1.1 requests without a Host header, which
+	// RFC 9112 section 3.2 otherwise requires rejecting with ERROR_MISSING_HOST.
+	// Repeated or invalid Host headers are rejected either way.
+	AllowMissingHost bool
+
+	// AllowBareLF accepts lines terminated by \n alone, as some legacy
+	// clients send. A bare CR is still rejected.
+	AllowBareLF bool
+
+	// AllowLeadingBlankLines skips empty lines before the request line, which
+	// RFC 9112 section 2.2 suggests for clients that send an extra CRLF after a POST body
+	AllowLeadingBlankLines bool
+
+	// AllowDuplicateContentLength accepts repeated Content-Length fields
+	// with identical values ("Content-Length: 5" twice) as one.
+	// Differing values are always rejected.
+	AllowDuplicateContentLength bool
+
+	// PreserveHeaderCase records the spelling each header name arrived with in
+	// Request.HeaderCase, so a proxy can send it on unchanged (see Headers.WithCase)
+	PreserveHeaderCase bool
+}
+
+// StrictParserOptions is a conformance parser: nothing outside RFC 9112 is accepted
+var StrictParserOptions = ParserOptions{StrictMethod: true}
+
+// LenientParserOptions accepts every deviation ParserOptions knows how to tolerate
+var LenientParserOptions = ParserOptions{
+	AllowBareLF:                 true,
+	AllowLeadingBlankLines:      true,
+	AllowDuplicateContentLength: true,
+	AllowMissingHost:            true,
+}
+
+// checkMethod validates a parsed method: always the token grammar
+// (RFC 9110 section 9.1), then StrictMethod. AllowedMethods is checked
+// separately by allowsMethod, once the rest of the request has been read.
+func (o ParserOptions) checkMethod(method string) error {
+	if !headers.IsToken(method) {
+		return fmt.Errorf("%w: %q", ERROR_MALFORMED_METHOD, method)
+	}
+
+	if o.StrictMethod {
+		for i := 0; i < len(method); i++ {
+			if method[i] < 'A' || method[i] > 'Z' {
+				return fmt.Errorf("%w: %q", ERROR_MALFORMED_METHOD, method)
+			}
+		}
+	}
+
+	return nil
+}
+
+// allowsMethod checks a well-formed method against AllowedMethods
+func (o ParserOptions) allowsMethod(method string) error {
+	if len(o.AllowedMethods) > 0 && !slices.Contains(o.AllowedMethods, method) {
+		return fmt.Errorf("%w: %s", ERROR_UNSUPPORTED_METHOD, method)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package request
+
+import (
+	"strings"
+
+	"github.com/jrooke/httpfromtcp/internal/urlenc"
+)
+
+// Values holds query parameters, each key mapping to every value it was given
+// in the order they appeared
+// Example: "tag=go&tag=http&page=2" → {"tag": ["go", "http"], "page": ["2"]}
+type Values map[string][]string
+
+// Get returns the first value for key, or "" if there is none
+func (v Values) Get(key string) string {
+	if vs := v[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Add appends value to the values of key
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// All returns every value for key, nil if there is none
+func (v Values) All(key string) []string {
+	return v[key]
+}
+
+// ParseQuery parses a raw query string (without the "?").
+// Pairs are separated by "&" or ";", a key without "=" gets an empty value,
+// and keys and values are percent-decoded with "+" as a space.
+// Malformed escapes are kept as literal text rather than dropping the pair.
+// Example: "q=go+http&lang=en;debug" → {"q": ["go http"], "lang": ["en"], "debug": [""]}
+func ParseQuery(raw string) Values {
+	values := Values{}
+	decode := urlenc.Options{Lenient: true}
+
+	for _, pair := range strings.FieldsFunc(raw, func(r rune) bool { return r == '&' || r == ';' }) {
+		key, value, _ := strings.Cut(pair, "=")
+
+		// Lenient decoding never fails
+		key, _ = decode.QueryUnescape(key)
+		value, _ = decode.QueryUnescape(value)
+		values.Add(key, value)
+	}
+	return values
+}
+
+// Query returns the parameters in the request target's query string,
+// parsed on first use and cached. Returns empty Values if there is no query
+// or the target fails to parse (see URL).
+func (r *Request) Query() Values {
+	if r.query == nil {
+		rawQuery := ""
+		if u, err := r.URL(); err == nil {
+			rawQuery = u.RawQuery
+		}
+		r.query = ParseQuery(rawQuery)
+	}
+	return r.query
+}
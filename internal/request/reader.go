@@ -0,0 +1,359 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jrooke/httpfromtcp/internal/clock"
+)
+
+// The buffer starts small and doubles as needed, up to DefaultMaxBufferBytes
+const initialBufferBytes = 1024
+const DefaultMaxBufferBytes = 1024 * 1024
+
+// ERROR_BUFFER_FULL is returned when a single line (request line, header,
+// chunk size or trailer) doesn't fit in MaxBufferBytes
+var ERROR_BUFFER_FULL = fmt.Errorf("ERROR: Request Line Exceeds Read Buffer")
+
+// ERROR_BODY_NOT_CONSUMED is returned by ReadRequest when a streamed body
+// from the previous request hasn't been read to the end yet
+var ERROR_BODY_NOT_CONSUMED = fmt.Errorf("ERROR: Previous Request Body Not Consumed")
+
+// ERROR_DISCARD_LIMIT_EXCEEDED is returned by DiscardBody when more body remains than it may drain
+var ERROR_DISCARD_LIMIT_EXCEEDED = fmt.Errorf("ERROR: Unread Request Body Exceeds Discard Limit")
+
+// Reader parses consecutive requests from one connection.
+// It maintains an internal buffer and shifts unconsumed data to the beginning
+// of the buffer after each parse iteration, so bytes that arrive after one
+// request (e.g. a pipelined second request) are kept for the next ReadRequest.
+type Reader struct {
+	reader io.Reader
+
+	// Hooks are fired for every request read; see Hooks
+	Hooks Hooks
+
+	// Limits bound the size of each request head, DefaultLimits unless changed
+	Limits Limits
+
+	// Clock measures the durations reported to Hooks, clock.System unless changed
+	Clock clock.Clock
+
+	// Options pick how strictly requests are parsed, see ParserOptions
+	Options ParserOptions
+
+	// MaxBufferBytes caps how far the internal buffer may grow, as a last line
+	// of defence when Limits are disabled. Bodies stream through the buffer
+	// and never need it to grow.
+	MaxBufferBytes int
+
+	// StreamBody makes ReadRequest return right after the headers, leaving
+	// the body on the connection to be read through Request.BodyReader.
+	// Request.Body stays empty in this mode.
+	StreamBody bool
+
+	// BodySinks, when set, has ReadRequest read each body into a BodySink
+	// picked by the policy (memory, temp file or the caller's own) instead
+	// of Request.Body, and hand it out through Request.BodyFile. The request
+	// must be closed to release it. StreamBody takes precedence.
+	BodySinks *BodySinkPolicy
+
+	// current is the request last returned by ReadRequest
+	current *Request
+
+	// buf holds bytes read from reader, buf[:bufIdx] is not parsed yet
+	buf    []byte
+	bufIdx int
+
+	// consumed counts every byte the parser has used up since the Reader was created
+	consumed int64
+}
+
+// Constructor function to create a Reader with a 1024 byte buffer and DefaultLimits
+// The buffer doubles whenever a line doesn't fit, so Limits (not the initial
+// buffer size) decide how large a request head can get.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader:         reader,
+		Limits:         DefaultLimits,
+		Clock:          clock.System,
+		MaxBufferBytes: DefaultMaxBufferBytes,
+		buf:            make([]byte, initialBufferBytes),
+	}
+}
+
+// grow doubles the buffer, capped at MaxBufferBytes
+// Example: 1024 → 2048 → 4096 → ... → MaxBufferBytes
+func (rr *Reader) grow() error {
+	size := len(rr.buf) * 2
+	if rr.MaxBufferBytes > 0 && size > rr.MaxBufferBytes {
+		size = rr.MaxBufferBytes
+	}
+	if size <= len(rr.buf) {
+		return ERROR_BUFFER_FULL
+	}
+
+	grown := make([]byte, size)
+	copy(grown, rr.buf[:rr.bufIdx])
+	rr.buf = grown
+	return nil
+}
+
+// Offset returns how many bytes of the stream the parser has consumed so far.
+// Read before ReadRequest it is the offset the next request starts at,
+// since bytes are only consumed once they've been parsed.
+func (rr *Reader) Offset() int64 {
+	return rr.consumed
+}
+
+// Resumable reports whether the error from the last ReadRequest left the
+// stream at the start of the next request, so a server can answer it and
+// keep reading. Only an unsupported method or a bad Host on an HTTP/1.1
+// request without a body, and without "Connection: close", is; after any
+// other error the rest of the bad request is still on the connection and
+// it has to be closed.
+func (rr *Reader) Resumable() bool {
+	return rr.current != nil && rr.current.state == StateError && rr.current.resumable
+}
+
+// Buffered returns a copy of the bytes read from the connection but not
+// parsed yet, e.g. the start of a pipelined request or of another protocol
+// after an Upgrade. The caller taking over the connection should read these
+// before reading from it again.
+func (rr *Reader) Buffered() []byte {
+	return bytes.Clone(rr.buf[:rr.bufIdx])
+}
+
+// ReadRequest reads and parses the next request.
+// Returns io.EOF if the connection was closed cleanly before a new request started,
+// or io.ErrUnexpectedEOF if it was closed partway through one.
+// With StreamBody set it returns as soon as the headers are parsed and the
+// body must be read to the end through BodyReader before calling ReadRequest again.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	if rr.current != nil && !rr.current.done() {
+		return nil, ERROR_BODY_NOT_CONSUMED
+	}
+
+	// Create a new request with StateInit
+	request := newRequest(rr.Limits)
+	request.options = rr.Options
+	request.progress.start = rr.Clock.Now()
+	rr.current = request
+
+	// Loop until the request is complete (or just its head, when streaming) or has an error
+	for {
+		if err := rr.step(request); err != nil {
+			return nil, err
+		}
+
+		// Sink any body, even one that arrived whole with the headers
+		if rr.BodySinks != nil && !rr.StreamBody && request.headersDone() && (!request.done() || len(request.Body) > 0) {
+			if err := rr.sinkBody(request); err != nil {
+				return nil, err
+			}
+			return request, nil
+		}
+
+		if request.done() {
+			return request, nil
+		}
+
+		if rr.StreamBody && request.headersDone() {
+			// Body bytes that arrived with the headers are the first thing the body reader returns
+			request.body = &bodyReader{
+				reader:  rr,
+				request: request,
+				pending: request.Body,
+			}
+			request.Body = nil
+			return request, nil
+		}
+
+		if err := rr.fill(request); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// step parses whatever is buffered, reports progress to the hooks
+// and drops the consumed bytes from the buffer
+func (rr *Reader) step(request *Request) error {
+	hooks := rr.Hooks
+	progress := &request.progress
+	bodyBefore := len(request.Body)
+
+	// Parse the buffer to extract the HTTP request line, headers and body
+	// Returns readN = number of bytes consumed (including \r\n)
+	// If readN is 0, there's incomplete data, the caller reads more
+	// If error, the request is malformed, return error
+	readN, err := request.parse(rr.buf[:rr.bufIdx])
+	if err != nil {
+		// A resumable error comes with the whole request consumed, drop it
+		// so the next ReadRequest starts at the following request
+		if request.resumable {
+			rr.discard(readN)
+		}
+		return err
+	}
+	progress.bodyRead += len(request.Body) - bodyBefore
+
+	// The request line is done as soon as we've moved past StateInit
+	if !progress.requestLineReported && request.state != StateInit && request.state != StateError {
+		progress.requestLineReported = true
+		if hooks.OnRequestLine != nil {
+			hooks.OnRequestLine(rr.Clock.Now().Sub(progress.start))
+		}
+	}
+	// Likewise the headers are done once we've reached the body or the end
+	if !progress.headersReported && request.headersDone() {
+		progress.headersReported = true
+		if hooks.OnHeaders != nil {
+			hooks.OnHeaders(rr.Clock.Now().Sub(progress.start))
+		}
+	}
+	if !progress.bodyReported && request.state == StateDone {
+		progress.bodyReported = true
+		if hooks.OnBody != nil {
+			hooks.OnBody(progress.bodyRead, rr.Clock.Now().Sub(progress.start))
+		}
+	}
+
+	rr.discard(readN)
+	return nil
+}
+
+// discard drops the first readN bytes of the buffer once they've been parsed
+func (rr *Reader) discard(readN int) {
+	// Shift unconsumed bytes to the front of the buffer
+	// buf[readN:bufIdx] = all bytes after what was parsed
+	// Example: if buffer has "GET / HTTP/1.1\r\nHost: example.com" and readN=18
+	// This copies "Host: example.com" to the front
+	copy(rr.buf, rr.buf[readN:rr.bufIdx])
+
+	// Adjust buffer index to account for consumed bytes
+	// If bufIdx was 35 and readN was 18, bufIdx becomes 17
+	// Now the unconsumed data occupies buf[0:17]
+	rr.bufIdx -= readN
+	rr.consumed += int64(readN)
+}
+
+// fill reads more bytes from the connection into the buffer
+func (rr *Reader) fill(request *Request) error {
+	hooks := rr.Hooks
+	progress := &request.progress
+
+	// The buffer is full of an incomplete line: make room for more
+	// The limits checked in parse usually fail before MaxBufferBytes is reached
+	if rr.bufIdx == len(rr.buf) {
+		if err := rr.grow(); err != nil {
+			return err
+		}
+	}
+
+	// Read as many bytes as fit from TCP connection into buf starting at bufIdx
+	// n is the number of bytes that were actually read
+	n, err := rr.reader.Read(rr.buf[rr.bufIdx:])
+
+	// Report progress to the instrumentation hooks
+	if hooks.OnRead != nil && n > 0 {
+		hooks.OnRead(n)
+	}
+	if hooks.OnFirstByte != nil && progress.totalRead == 0 && n > 0 {
+		hooks.OnFirstByte(rr.Clock.Now().Sub(progress.start))
+	}
+	progress.totalRead += n
+
+	// Advance buffer index by the number of bytes just read
+	// bufIdx now represents total data currently in the buffer
+	// Example: bufIdx was 0, read 256 bytes, now bufIdx = 256
+	rr.bufIdx += n
+
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+		// Parse what arrived with the EOF before giving up,
+		// the next fill will see the EOF again with nothing read
+		if n > 0 {
+			return nil
+		}
+		// Nothing of a new request received: the peer simply closed the connection
+		if rr.bufIdx == 0 && request.state == StateInit {
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// DiscardBody reads and throws away whatever the handler left of a streamed body,
+// so the next request on the connection starts at the right byte.
+// At most max bytes are drained: if more remain it gives up with
+// ERROR_DISCARD_LIMIT_EXCEEDED and the caller should close the connection
+// rather than spend time reading an upload nobody wants.
+// Bodies read in full with the request (not streamed, or small enough to
+// arrive with the headers) are already consumed and return nil.
+func DiscardBody(req *Request, max int64) error {
+	if req.body == nil {
+		return nil
+	}
+
+	// Read one byte past max so we can tell "exactly max" from "more than max"
+	n, err := io.Copy(io.Discard, io.LimitReader(req.body, max+1))
+	if err != nil {
+		return err
+	}
+	if n > max {
+		return ERROR_DISCARD_LIMIT_EXCEEDED
+	}
+	return nil
+}
+
+// bodyReader streams a request body straight from the connection,
+// reusing the request's parser states for Content-Length and chunked bodies
+type bodyReader struct {
+	reader  *Reader
+	request *Request
+
+	// pending holds decoded body bytes not yet returned to the caller
+	pending []byte
+	err     error
+}
+
+// Read returns decoded body bytes, reading from the connection only when
+// nothing is pending. Returns io.EOF once the whole body (and any trailers) is read.
+func (b *bodyReader) Read(p []byte) (int, error) {
+	for {
+		if len(b.pending) > 0 {
+			n := copy(p, b.pending)
+			b.pending = b.pending[n:]
+			return n, nil
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.request.state == StateDone {
+			return 0, io.EOF
+		}
+
+		if err := b.reader.step(b.request); err != nil {
+			b.err = err
+			continue
+		}
+
+		// Move whatever the parser decoded into pending
+		if len(b.request.Body) > 0 {
+			b.pending = b.request.Body
+			b.request.Body = nil
+			continue
+		}
+		if b.request.state == StateDone {
+			continue
+		}
+
+		if err := b.reader.fill(b.request); err != nil {
+			b.err = err
+		}
+	}
+}
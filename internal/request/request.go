@@ -2,8 +2,16 @@ package request
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/jrooke/httpfromtcp/internal/url"
 )
 
 // We are trying to parse a line
@@ -12,26 +20,166 @@ type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+
+	// TargetForm is which of the four request-target forms RequestTarget uses
+	TargetForm TargetForm
 }
 
+// TargetForm is the shape of a request target (RFC 9112 section 3.2)
+type TargetForm string
+
+const (
+	// TargetOrigin is the usual "/path?query"
+	TargetOrigin TargetForm = "origin"
+
+	// TargetAbsolute is a full URI, "http://example.com/path", sent to proxies
+	TargetAbsolute TargetForm = "absolute"
+
+	// TargetAuthority is "host:port", only used by CONNECT
+	TargetAuthority TargetForm = "authority"
+
+	// TargetAsterisk is "*", only used by a server-wide OPTIONS
+	TargetAsterisk TargetForm = "asterisk"
+)
+
 // Method receives a pointer to a RequestLine struct and
-// returns whether or not the HTTP Version is exactly 1.1
+// returns whether or not the HTTP Version is one we speak (1.0 or 1.1)
 func (r *RequestLine) ValidHTTP() bool {
-	return r.HttpVersion == "HTTP/1.1"
+	return r.HttpVersion == "1.1" || r.HttpVersion == "1.0"
+}
+
+// IsHTTP10 reports whether the request came from an HTTP/1.0 client, which
+// means no persistent connection unless asked for and no chunked coding
+func (r *Request) IsHTTP10() bool {
+	return r.RequestLine.HttpVersion == "1.0"
 }
 
 // The general Request struct which contains
-// RequestLine nested within (Method, HTTP Version, etc.) and
-// the state of the request (init, done, error) to identify when to exit
+// RequestLine nested within (Method, HTTP Version, etc.),
+// the parsed Headers, the Body (empty when there is no Content-Length or chunked body),
+// any Trailers sent after a chunked body and
+// the state of the request (init, headers, body, done, error) to identify when to exit
 type Request struct {
 	RequestLine RequestLine
+	Headers     headers.Headers
+	Body        []byte
+	Trailers    headers.Headers
 	state       parserState
+
+	// RemoteAddr and LocalAddr are the client's and our end of the connection
+	// the request came in on (the real client behind a PROXY protocol header).
+	// TLS is its state (version, SNI server name, peer certificates), nil when
+	// it wasn't TLS. All three are filled in by the server, the parser doesn't
+	// see the connection, so they're nil for requests parsed directly.
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	TLS        *tls.ConnectionState
+
+	// PathParams are the path parameters captured by the route that matched,
+	// see router.Router. Nil when the request wasn't routed.
+	PathParams map[string]string
+
+	// HeaderCase maps each canonical header name to the spelling it was first
+	// received with. Only filled in with ParserOptions.PreserveHeaderCase.
+	HeaderCase map[string]string
+
+	// bodyLength is the Content-Length announced in the headers
+	// bodyReceived counts body bytes parsed so far, which may already
+	// have been handed out by BodyReader when streaming
+	bodyLength   int
+	bodyReceived int
+
+	// chunkRemaining is how many bytes of the current chunk are still to be read
+	chunkRemaining int
+
+	// trailerBytes and trailerCount track the trailer section against the header limits
+	trailerBytes int
+	trailerCount int
+
+	// limits bound the request head; headerBytes and headerCount track progress against them
+	limits      Limits
+	headerBytes int
+	headerCount int
+
+	// options are the Reader's ParserOptions
+	options ParserOptions
+
+	// deferredErr is an error that doesn't stop the request head from being
+	// parsed (an unsupported method, a bad Host), returned once the head is done.
+	// resumable is set when it was returned with the whole request consumed,
+	// see Reader.Resumable.
+	deferredErr error
+	resumable   bool
+
+	// body streams the body from the connection when the Reader has StreamBody set
+	body *bodyReader
+
+	// sink holds the body when the Reader has BodySinks set, bodyFile is its handle
+	sink     BodySink
+	bodyFile io.ReadSeeker
+
+	// progress tracks what has been reported to the Reader's Hooks
+	progress parseProgress
+
+	// query caches the parsed query string, see Query
+	query Values
+
+	// url and urlErr cache the parsed request target, see URL
+	url    *url.URL
+	urlErr error
+}
+
+// parseProgress records timings and counts for Hooks while a request is parsed
+type parseProgress struct {
+	start               time.Time
+	totalRead           int
+	bodyRead            int
+	requestLineReported bool
+	headersReported     bool
+	bodyReported        bool
+}
+
+// BodyReader returns the request body as a stream. For requests read with
+// StreamBody the bytes come straight from the connection (Content-Length or
+// chunked), so handlers can io.Copy large uploads without holding them in memory.
+// With BodySinks it reads BodyFile. Otherwise it reads from the already buffered Body.
+func (r *Request) BodyReader() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+	if r.bodyFile != nil {
+		return r.bodyFile
+	}
+	return bytes.NewReader(r.Body)
+}
+
+// URL returns the request target parsed into a url.URL, on first use,
+// and caches it (or the error) for later calls. Query and Host
+// both read the target through it.
+// Errors wrap ERROR_MALFORMED_TARGET.
+func (r *Request) URL() (*url.URL, error) {
+	if r.url == nil && r.urlErr == nil {
+		r.url, r.urlErr = url.Parse(r.RequestLine.RequestTarget)
+		if r.urlErr != nil {
+			r.urlErr = fmt.Errorf("%w: %w", ERROR_MALFORMED_TARGET, r.urlErr)
+		}
+	}
+	return r.url, r.urlErr
 }
 
-// Initializes a new Request with StateInit and returns a pointer to it
-func newRequest() *Request {
+// PathValue returns the path parameter captured as name, or "" if there is none
+// Example: routed by "/users/{id}", "/users/42" → PathValue("id") = "42"
+func (r *Request) PathValue(name string) string {
+	return r.PathParams[name]
+}
+
+// Initializes a new Request with StateInit and empty Headers and Trailers and returns a pointer to it
+func newRequest(limits Limits) *Request {
 	return &Request{
-		state: StateInit,
+		state:    StateInit,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		limits:   limits,
 	}
 }
 
@@ -39,9 +187,17 @@ func newRequest() *Request {
 type parserState string
 
 const (
-	StateInit  parserState = "init"
-	StateDone  parserState = "done"
-	StateError parserState = "error"
+	StateInit    parserState = "init"
+	StateHeaders parserState = "headers"
+	StateBody    parserState = "body"
+
+	// Chunked transfer coding: size line, chunk data, CRLF after data, trailer section
+	StateChunkSize    parserState = "chunk-size"
+	StateChunkData    parserState = "chunk-data"
+	StateChunkDataEnd parserState = "chunk-data-end"
+	StateTrailers     parserState = "trailers"
+	StateDone         parserState = "done"
+	StateError        parserState = "error"
 )
 
 // Constants, including error codes and
@@ -49,12 +205,28 @@ const (
 var ERROR_MALFORMED_REQUEST_LINE = fmt.Errorf("ERRIR: Malformed Request Line")
 var ERROR_UNSUPPORTED_HTTP_VERSION = fmt.Errorf("ERROR: Unsupported HTTP Version")
 var ERROR_REQUEST_IN_ERROR_STATE = fmt.Errorf("Request in error state.")
+var ERROR_MALFORMED_CONTENT_LENGTH = fmt.Errorf("ERROR: Malformed Content-Length")
+var ERROR_CONFLICTING_BODY_LENGTH = fmt.Errorf("ERROR: Both Content-Length and Transfer-Encoding Present")
 var SEPARATOR = []byte("\r\n")
 
+// ERROR_MALFORMED_TARGET is returned for a request target in no form its
+// method can use, or with a bad percent-encoding
+var ERROR_MALFORMED_TARGET = fmt.Errorf("ERROR: Malformed Request Target")
+
+// ParseRequestLine parses the request line at the start of b
+// Returns: (parsed line or nil if incomplete, bytes consumed, error)
 func ParseRequestLine(b []byte) (*RequestLine, int, error) {
-	// Search for the \r\n separator in the byte slice
+	return parseRequestLine(b, ParserOptions{})
+}
+
+// parseRequestLine is ParseRequestLine following the given options
+func parseRequestLine(b []byte, options ParserOptions) (*RequestLine, int, error) {
+	// Search for the \r\n separator (or \n when allowed) in the byte slice
 	// Returns the index where it starts, or -1 if not found
-	idx := bytes.Index(b, SEPARATOR)
+	idx, sepLen, err := headers.LineEnd(b, options.AllowBareLF)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	// If separator not found, we don't have a complete request line yet
 	// Return nil (no data parsed), 0 bytes consumed, and nil error (not an error, just incomplete)
@@ -68,9 +240,9 @@ func ParseRequestLine(b []byte) (*RequestLine, int, error) {
 	startLine := b[:idx]
 
 	// Calculate how many bytes to skip to get past the separator
-	// idx = position of \r, len(SEPARATOR) = 2 (\r\n), so we skip past both
+	// idx = position of \r, sepLen = 2 (\r\n), so we skip past both
 	// Example: if idx=15, read=17 means skip to byte 17 (past the \r\n)
-	read := idx + len(SEPARATOR)
+	read := idx + sepLen
 
 	// Split the request line by spaces into parts
 	// Should have exactly 3 parts: METHOD, REQUEST_TARGET, HTTP_VERSION
@@ -81,25 +253,67 @@ func ParseRequestLine(b []byte) (*RequestLine, int, error) {
 	}
 
 	// Split the HTTP version part by "/" to validate format
-	// Should be "HTTP" / "1.1"
+	// Should be "HTTP" / DIGIT "." DIGIT
 	// Example: "HTTP/1.1" → ["HTTP", "1.1"]
 	httpParts := bytes.Split(parts[2], []byte("/"))
-	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || string(httpParts[1]) != "1.1" {
+	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || !validVersion(httpParts[1]) {
 		return nil, 0, ERROR_MALFORMED_REQUEST_LINE
 	}
 
-	// Create the RequestLine struct with the parsed values
-	// Convert byte slices to strings
-	rl := &RequestLine{
-		Method:        string(parts[0]),
-		RequestTarget: string(parts[1]),
-		HttpVersion:   string(httpParts[1]),
+	// Well-formed, but we only speak 1.0 and 1.1
+	rl := &RequestLine{HttpVersion: string(httpParts[1])}
+	if !rl.ValidHTTP() {
+		return nil, 0, fmt.Errorf("%w: HTTP/%s", ERROR_UNSUPPORTED_HTTP_VERSION, rl.HttpVersion)
 	}
 
+	if err := options.checkMethod(string(parts[0])); err != nil {
+		return nil, 0, err
+	}
+
+	form, err := targetForm(string(parts[0]), string(parts[1]))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Fill in the RequestLine struct with the parsed values
+	// Convert byte slices to strings
+	rl.Method = string(parts[0])
+	rl.RequestTarget = string(parts[1])
+	rl.TargetForm = form
+
 	// Return the parsed RequestLine, bytes consumed (including \r\n), and no error
 	return rl, read, nil
 }
 
+// validVersion reports whether v is DIGIT "." DIGIT, e.g. "1.1"
+func validVersion(v []byte) bool {
+	return len(v) == 3 && v[0] >= '0' && v[0] <= '9' && v[1] == '.' && v[2] >= '0' && v[2] <= '9'
+}
+
+// targetForm works out which form target is in, rejecting forms the method can't use
+// Example: ("CONNECT", "example.com:443") → TargetAuthority
+func targetForm(method, target string) (TargetForm, error) {
+	switch {
+	case method == "CONNECT":
+		// authority-form is host:port and nothing else
+		host, port, ok := strings.Cut(target, ":")
+		if !ok || host == "" || port == "" || strings.ContainsAny(target, "/?#@") {
+			return "", fmt.Errorf("%w: CONNECT needs host:port, got %q", ERROR_MALFORMED_TARGET, target)
+		}
+		return TargetAuthority, nil
+	case target == "*":
+		if method != "OPTIONS" {
+			return "", fmt.Errorf("%w: \"*\" is only allowed with OPTIONS", ERROR_MALFORMED_TARGET)
+		}
+		return TargetAsterisk, nil
+	case strings.HasPrefix(target, "/"):
+		return TargetOrigin, nil
+	case url.HasScheme(target):
+		return TargetAbsolute, nil
+	}
+	return "", fmt.Errorf("%w: %q", ERROR_MALFORMED_TARGET, target)
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 
 	read := 0
@@ -110,7 +324,21 @@ outer:
 		case StateError:
 			return 0, ERROR_REQUEST_IN_ERROR_STATE
 		case StateInit:
-			rl, n, err := ParseRequestLine(data[read:])
+			if err := r.limits.checkRequestLine(data[read:]); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+
+			// Skip blank lines left over from a previous message, when allowed
+			if r.options.AllowLeadingBlankLines {
+				idx, sepLen, err := headers.LineEnd(data[read:], r.options.AllowBareLF)
+				if err == nil && idx == 0 {
+					read += sepLen
+					continue
+				}
+			}
+
+			rl, n, err := parseRequestLine(data[read:], r.options)
 			if err != nil {
 				r.state = StateError
 				return 0, err
@@ -119,77 +347,252 @@ outer:
 				break outer
 			}
 
+			if err := r.limits.checkTarget(rl.RequestTarget); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+
 			r.RequestLine = *rl
 			read += n
 
-			r.state = StateDone
+			// An unsupported method is answered once the head is read, so that
+			// without a body the connection can carry on (501 needn't close it)
+			r.deferredErr = r.options.allowsMethod(rl.Method)
+
+			r.state = StateHeaders
+
+		case StateHeaders:
+			// Feed the remaining bytes to the headers parser one field line at a time
+			// n == 0 means the next line is incomplete, wait for more data
+			n, done, err := r.Headers.ParseLine(data[read:], r.options.AllowBareLF)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if !done && n > 0 {
+				r.headerBytes += n
+				r.headerCount++
+				if r.options.PreserveHeaderCase {
+					r.recordHeaderCase(data[read : read+n])
+				}
+			}
+			if !done {
+				if err := r.limits.checkHeaders(r.headerBytes, r.headerCount, data[read+n:]); err != nil {
+					r.state = StateError
+					return 0, err
+				}
+			}
+			if n == 0 {
+				break outer
+			}
+
+			read += n
+
+			// The empty line after the headers ends the request head
+			// Move on to the body only if the headers announced one
+			if done {
+				// A request carrying both framings is how request smuggling works:
+				// a proxy honouring one and a server honouring the other disagree on
+				// where the body ends. RFC 9112 section 6.3 lets us reject it outright.
+				if r.Headers.Has("Transfer-Encoding") && r.Headers.Has("Content-Length") {
+					r.state = StateError
+					return 0, ERROR_CONFLICTING_BODY_LENGTH
+				}
+
+				// HTTP/1.0 has no transfer codings, so the body length is unknowable
+				if r.IsHTTP10() && r.Headers.Has("Transfer-Encoding") {
+					r.state = StateError
+					return 0, fmt.Errorf("%w in HTTP/1.0", ERROR_UNSUPPORTED_TRANSFER_ENCODING)
+				}
+
+				if err := r.checkHost(); err != nil && r.deferredErr == nil {
+					r.deferredErr = err
+				}
+
+				chunked, err := isChunked(r.Headers)
+				if err != nil {
+					r.state = StateError
+					return 0, err
+				}
+
+				length := 0
+				if !chunked {
+					length, err = contentLength(r.Headers, r.options.AllowDuplicateContentLength)
+					if err != nil {
+						r.state = StateError
+						return 0, err
+					}
+				}
+
+				if r.deferredErr != nil {
+					r.state = StateError
+
+					// Without a body the whole request has been consumed, so the
+					// next one can still be read. An HTTP/1.0 client, or one that
+					// sent "Connection: close", expects a close anyway.
+					if !chunked && length == 0 && !r.IsHTTP10() && !r.asksToClose() {
+						r.resumable = true
+						return read, r.deferredErr
+					}
+					return 0, r.deferredErr
+				}
+
+				if chunked {
+					r.state = StateChunkSize
+					continue
+				}
+
+				r.bodyLength = length
+				if length == 0 {
+					r.state = StateDone
+				} else {
+					r.state = StateBody
+				}
+			}
+
+		case StateBody:
+			// Take at most the bytes still missing from the body
+			// Anything after that belongs to whatever follows this request
+			remaining := r.bodyLength - r.bodyReceived
+			n := min(remaining, len(data[read:]))
+			if n == 0 {
+				break outer
+			}
+
+			r.Body = append(r.Body, data[read:read+n]...)
+			read += n
+
+			r.bodyReceived += n
+			if r.bodyReceived == r.bodyLength {
+				r.state = StateDone
+			}
+
+		case StateChunkSize, StateChunkData, StateChunkDataEnd, StateTrailers:
+			n, err := r.parseChunked(data[read:])
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if n == 0 {
+				break outer
+			}
+
+			read += n
 
 		case StateDone:
 			break outer
 		}
-		return read, nil
 	}
 	return read, nil
 }
 
-func (r *Request) done() bool {
-	return r.state == StateDone || r.state == StateError
-}
+// contentLength reads the Content-Length header
+// Returns 0 if the header is missing, or an error if it isn't a non-negative integer.
+// A Content-Length that is present but blank is an error too: reading it as
+// "no body" would leave the body to be parsed as the next request.
+// Repeated Content-Length fields read back comma-joined ("5, 5"). RFC 9112 section 6.3
+// lets a recipient treat identical values as one, which allowDuplicates enables;
+// otherwise any repetition is rejected as malformed.
+func contentLength(h headers.Headers, allowDuplicates bool) (int, error) {
+	if !h.Has("Content-Length") {
+		return 0, nil
+	}
+	value := h.Get("Content-Length")
 
-// RequestFromReader reads data from an io.Reader and parses it into a Request.
-// It continuously reads data in chunks of up to 1024 bytes, parsing the HTTP request
-// line until the request is complete (done) or an error occurs. The function maintains
-// an internal buffer and shifts unconsumed data to the beginning of the buffer after
-// each parse iteration. Returns a pointer to the parsed Request and any error encountered
-// during reading or parsing.
-func RequestFromReader(reader io.Reader) (*Request, error) {
+	if allowDuplicates {
+		values := h.List("Content-Length")
+		if len(values) == 0 {
+			return 0, ERROR_MALFORMED_CONTENT_LENGTH
+		}
+		for _, v := range values[1:] {
+			if v != values[0] {
+				return 0, ERROR_MALFORMED_CONTENT_LENGTH
+			}
+		}
+		value = values[0]
+	}
 
-	// Create a new request with StateInit
-	request := newRequest()
+	// Only plain digits are allowed (strconv.Atoi would also accept "+5")
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, ERROR_MALFORMED_CONTENT_LENGTH
+		}
+	}
 
-	// Create a 1024 byte array to store the incoming info.
-	// NOTE: Buffer could get overrun.
-	buf := make([]byte, 1024)
+	length, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, ERROR_MALFORMED_CONTENT_LENGTH
+	}
+	return length, nil
+}
 
-	// Set the buffer index to the beginning
-	bufIdx := 0
+// recordHeaderCase remembers how the name of a parsed field line was spelled
+// The first spelling wins when the same field is repeated.
+func (r *Request) recordHeaderCase(line []byte) {
+	name := string(line[:bytes.IndexByte(line, ':')])
+	canonical := headers.CanonicalName(name)
+	if r.HeaderCase == nil {
+		r.HeaderCase = map[string]string{}
+	}
+	if _, ok := r.HeaderCase[canonical]; !ok {
+		r.HeaderCase[canonical] = name
+	}
+}
 
-	// Loop until the request is complete or has an error
-	for !request.done() {
-		// Read up to 1024 bytes from TCP connection into buf starting at bufIdx
-		// n is the number of bytes that were actually read
-		n, err := reader.Read(buf[bufIdx:])
-		// TODO: Decide what to do with error.
-		if err != nil {
-			return nil, err
+// headersDone reports whether parsing has moved past the request head
+// asksToClose reports whether the request carries "Connection: close"
+func (r *Request) asksToClose() bool {
+	for _, option := range r.Headers.List("Connection") {
+		if strings.EqualFold(option, "close") {
+			return true
 		}
+	}
+	return false
+}
 
-		// Advance buffer index by the number of bytes just read
-		// bufIdx now represents total data currently in the buffer
-		// Example: bufIdx was 0, read 256 bytes, now bufIdx = 256
-		bufIdx += n
-
-		// Parse the buffer to extract the HTTP request line
-		// Returns readN = number of bytes consumed (including \r\n)
-		// If readN is 0, there's incomplete data, loop continues to read more
-		// If error, the request is malformed, return error
-		readN, err := request.parse(buf[:bufIdx+n])
-		if err != nil {
-			return nil, err
-		}
+func (r *Request) headersDone() bool {
+	return r.state != StateInit && r.state != StateHeaders && r.state != StateError
+}
 
-		// Shift unconsumed bytes to the front of the buffer
-		// buf[readN:bufIdx] = all bytes after what was parsed
-		// Example: if buffer has "GET / HTTP/1.1\r\nHost: example.com" and readN=18
-		// This copies "Host: example.com" to the front
-		copy(buf, buf[readN:bufIdx])
+func (r *Request) done() bool {
+	return r.state == StateDone || r.state == StateError
+}
 
-		// Adjust buffer index to account for consumed bytes
-		// If bufIdx was 35 and readN was 18, bufIdx becomes 17
-		// Now the unconsumed data occupies buf[0:17]
-		bufIdx -= readN
-	}
+// Hooks are optional callbacks fired while a request is being parsed so a
+// server can populate metrics and spot slow clients without wrapping the reader.
+// Durations are measured from the moment parsing started. Any hook may be nil.
+type Hooks struct {
+	// OnRead is called after every read with the number of bytes received
+	OnRead func(n int)
+
+	// OnFirstByte is called once, when the first bytes of the request arrive
+	OnFirstByte func(elapsed time.Duration)
 
-	return request, nil
+	// OnRequestLine is called once the request line has been parsed
+	OnRequestLine func(elapsed time.Duration)
+
+	// OnHeaders is called once the whole header block has been parsed
+	OnHeaders func(elapsed time.Duration)
+
+	// OnBody is called once the whole body has been read, with its size in bytes
+	// Requests without a body report a size of 0
+	OnBody func(size int, elapsed time.Duration)
+}
+
+// RequestFromReader reads data from an io.Reader and parses it into a Request.
+// It continuously reads data into a buffer that starts at 1024 bytes, parsing the HTTP request
+// line, headers and body until the request is complete (done) or an error occurs.
+// Bytes read past the end of the request are discarded; use a Reader to parse
+// several requests from the same connection. Returns a pointer to the parsed
+// Request and any error encountered during reading or parsing.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithHooks(reader, Hooks{})
+}
 
+// RequestFromReaderWithHooks behaves like RequestFromReader but reports
+// parsing progress (bytes read, time to first byte, time to request line, headers and body) through hooks.
+func RequestFromReaderWithHooks(reader io.Reader, hooks Hooks) (*Request, error) {
+	r := NewReader(reader)
+	r.Hooks = hooks
+	return r.ReadRequest()
 }
@@ -1,10 +1,17 @@
 package request
 
 import (
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jrooke/httpfromtcp/internal/clock"
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/jrooke/httpfromtcp/internal/url"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -60,3 +67,977 @@ func TestRequestLineParse(t *testing.T) {
 	_, err = RequestFromReader(strings.NewReader("GET HTTP/1.1\r\n"))
 	require.Error(t, err)
 }
+
+func TestHeadersParse(t *testing.T) {
+	// Test: Standard Headers
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
+
+	// Test: Empty Headers
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 2,
+	}
+	lenient := NewReader(reader)
+	lenient.Options.AllowMissingHost = true
+	r, err = lenient.ReadRequest()
+	require.NoError(t, err)
+	assert.Empty(t, r.Headers)
+
+	// Test: Duplicate Headers
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Forwarded-For: 10.0.0.1\r\nx-forwarded-for: 10.0.0.2\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1, 10.0.0.2", r.Headers.Get("X-Forwarded-For"))
+
+	// Test: Malformed Header
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nHost : localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Missing End of Headers
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestBodyParse(t *testing.T) {
+	// Test: Standard Body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Empty Body, 0 reported content length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: Empty Body, no reported content length
+	reader = &chunkReader{
+		data: "GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: Body larger than the read buffer
+	body := strings.Repeat("x", 3000)
+	reader = &chunkReader{
+		data: "PUT /upload HTTP/1.1\r\nHost: localhost\r\n" +
+			"Content-Length: 3000\r\n" +
+			"\r\n" +
+			body,
+		numBytesPerRead: 700,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(r.Body))
+
+	// Test: Body shorter than reported content length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial content",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Malformed content length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Content-Length: +5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_CONTENT_LENGTH)
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;ext=1\r\n world!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+	assert.Empty(t, r.Trailers)
+
+	// Test: Announced trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Content-SHA256, X-Content-Length\r\n" +
+			"\r\n" +
+			"a\r\n0123456789\r\n" +
+			"0\r\n" +
+			"X-Content-SHA256: abc123\r\n" +
+			"x-content-length: 10\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, "abc123", r.Trailers.Get("X-Content-SHA256"))
+	assert.Equal(t, "10", r.Trailers.Get("X-Content-Length"))
+
+	// Test: Trailer not announced in the Trailer header
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"X-Other: nope\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_UNANNOUNCED_TRAILER)
+
+	// Test: A forbidden or unannounced trailer is rejected on its own line,
+	// without waiting for the end of the trailer section
+	for _, line := range []string{"Host: evil\r\n", "X-Other: nope\r\n"} {
+		_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: Host, X-Checksum\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			line))
+		assert.Error(t, err, line)
+		assert.NotErrorIs(t, err, io.ErrUnexpectedEOF, line)
+	}
+
+	// Test: Trailers are held to the header section limits
+	trailers := "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Checksum\r\n" +
+		"\r\n" +
+		"0\r\n" + strings.Repeat("X-Checksum: 1\r\n", 5)
+	reader2 := NewReader(strings.NewReader(trailers + "\r\n"))
+	reader2.Limits = Limits{MaxHeaderCount: 4}
+	_, err = reader2.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_HEADERS)
+	reader2 = NewReader(strings.NewReader(trailers + "X-Checksum: " + strings.Repeat("a", 100)))
+	reader2.Limits = Limits{MaxHeaderBytes: 100}
+	_, err = reader2.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// Test: Framing and routing fields are rejected in trailers even when announced
+	for _, name := range []string{"Content-Length", "transfer-encoding", "Host", "Trailer", "Authorization"} {
+		_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: " + name + "\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			name + ": 1\r\n" +
+			"\r\n"))
+		assert.ErrorIs(t, err, ERROR_FORBIDDEN_TRAILER, name)
+	}
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+
+	// Test: Missing CRLF after chunk data
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+
+	// Test: Unsupported transfer coding
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: gzip\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_UNSUPPORTED_TRANSFER_ENCODING)
+}
+
+func TestRequestHooks(t *testing.T) {
+	// Test: Hooks report bytes read, first byte and request line completion
+	reader := &chunkReader{
+		data:            "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	bytesRead := 0
+	firstByte := 0
+	requestLine := 0
+	headersDone := 0
+	bodySize := -1
+	r, err := RequestFromReaderWithHooks(reader, Hooks{
+		OnRead:        func(n int) { bytesRead += n },
+		OnFirstByte:   func(time.Duration) { firstByte++ },
+		OnRequestLine: func(time.Duration) { requestLine++ },
+		OnHeaders:     func(time.Duration) { headersDone++ },
+		OnBody:        func(size int, _ time.Duration) { bodySize = size },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+	assert.Equal(t, 47, bytesRead)
+	assert.Equal(t, 1, firstByte)
+	assert.Equal(t, 1, requestLine)
+	assert.Equal(t, 1, headersDone)
+	assert.Equal(t, 0, bodySize)
+
+	// Test: Durations come from the Reader's Clock, here 10ms per read
+	fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	timed := NewReader(&chunkReader{
+		data:            "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	timed.Clock = fake
+	elapsed := map[string]time.Duration{}
+	timed.Hooks = Hooks{
+		OnRead:        func(int) { fake.Advance(10 * time.Millisecond) },
+		OnFirstByte:   func(d time.Duration) { elapsed["first byte"] = d },
+		OnRequestLine: func(d time.Duration) { elapsed["request line"] = d },
+		OnHeaders:     func(d time.Duration) { elapsed["headers"] = d },
+	}
+	_, err = timed.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"first byte":   10 * time.Millisecond,
+		"request line": 60 * time.Millisecond,
+		"headers":      120 * time.Millisecond,
+	}, elapsed)
+}
+
+func TestReaderMultipleRequests(t *testing.T) {
+	// Test: Pipelined requests on one connection
+	reader := NewReader(&chunkReader{
+		data: "POST /one HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 1024,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/one", r.RequestLine.RequestTarget)
+	assert.Equal(t, "abc", string(r.Body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/two", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost", r.Headers.Get("Host"))
+
+	// Test: Clean EOF between requests
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: EOF partway through a request
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: local"))
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{MaxRequestLineBytes: 64, MaxHeaderBytes: 128, MaxHeaderCount: 3}
+
+	// Test: Request line over the limit, even before its \r\n arrives
+	reader := NewReader(strings.NewReader("GET /" + strings.Repeat("a", 100)))
+	reader.Limits = limits
+	_, err := reader.ReadRequest()
+	assert.Equal(t, ERROR_REQUEST_LINE_TOO_LONG, err)
+
+	// Test: Request target over its own limit, with the sizes in the error
+	reader = NewReader(strings.NewReader("GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	reader.Limits = Limits{MaxRequestLineBytes: 64, MaxTargetBytes: 32}
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, ERROR_TARGET_TOO_LONG)
+	assert.Contains(t, err.Error(), "41 bytes, the limit is 32 bytes")
+
+	// Test: Header section over the byte limit
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: " + strings.Repeat("c", 200) + "\r\n\r\n"))
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_HEADERS_TOO_LARGE, err)
+
+	// Test: Too many header lines
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n"))
+	reader.Limits = limits
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_TOO_MANY_HEADERS, err)
+
+	// Test: Request head larger than the initial buffer but within the limits
+	cookie := strings.Repeat("c", 5000)
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: " + cookie + "\r\n\r\n",
+		numBytesPerRead: 1000,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, cookie, r.Headers.Get("Cookie"))
+
+	// Test: Chunk-size line over the limit, even before its \r\n arrives
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=" + strings.Repeat("x", 100)))
+	reader.Limits = Limits{MaxChunkSizeLineBytes: 32}
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_CHUNK_SIZE_LINE_TOO_LONG, err)
+
+	// Test: Chunk extensions within the limit are fine
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=yes\r\nhello\r\n0\r\n\r\n"))
+	reader.Limits = Limits{MaxChunkSizeLineBytes: 32}
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestDuplicateContentLength(t *testing.T) {
+	data := "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Length: 5\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"
+
+	// Test: Strict mode rejects repeated Content-Length
+	_, err := RequestFromReader(strings.NewReader(data))
+	assert.Equal(t, ERROR_MALFORMED_CONTENT_LENGTH, err)
+
+	// Test: Lenient mode accepts identical values
+	reader := NewReader(strings.NewReader(data))
+	reader.Options.AllowDuplicateContentLength = true
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Lenient mode still rejects differing values
+	reader = NewReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!"))
+	reader.Options.AllowDuplicateContentLength = true
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_MALFORMED_CONTENT_LENGTH, err)
+
+	// Test: A blank Content-Length is rejected rather than read as no body,
+	// which would turn the body into a second, smuggled request
+	reader = NewReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: \r\n\r\n" +
+		"GET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_MALFORMED_CONTENT_LENGTH, err)
+	reader = NewReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: \r\nContent-Length: \r\n\r\n"))
+	reader.Options.AllowDuplicateContentLength = true
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_MALFORMED_CONTENT_LENGTH, err)
+
+	// Test: Content-Length together with Transfer-Encoding is rejected, in either order
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Length: 4\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"0\r\n\r\n"))
+	assert.Equal(t, ERROR_CONFLICTING_BODY_LENGTH, err)
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Content-Length: 4\r\n" +
+		"\r\n" +
+		"0\r\n\r\n"))
+	assert.Equal(t, ERROR_CONFLICTING_BODY_LENGTH, err)
+}
+
+func TestBareLF(t *testing.T) {
+	data := "POST /submit HTTP/1.1\n" +
+		"Host: localhost:42069\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\n" +
+		"5\nhello\n" +
+		"0\n" +
+		"\n"
+
+	// Test: Strict mode rejects LF-only lines
+	_, err := RequestFromReader(strings.NewReader(data))
+	require.Error(t, err)
+
+	// Test: Lenient mode accepts LF-only and mixed line endings
+	reader := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+	reader.Options.AllowBareLF = true
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost:42069", r.Headers.Get("Host"))
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Lenient mode still rejects bare CR
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\nHost: a\rb\n\n"))
+	reader.Options.AllowBareLF = true
+	_, err = reader.ReadRequest()
+	assert.Equal(t, headers.ERROR_BARE_CR, err)
+}
+
+func TestReaderBufferGrowth(t *testing.T) {
+	// Test: Request line longer than the initial buffer
+	target := "/" + strings.Repeat("a", 3000)
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 512,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, target, r.RequestLine.RequestTarget)
+
+	// Test: Many cookies adding up to more than the initial buffer
+	data := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	for i := 0; i < 20; i++ {
+		data += "Cookie: session" + strings.Repeat("x", 200) + "\r\n"
+	}
+	data += "\r\n"
+	r, err = RequestFromReader(&chunkReader{data: data, numBytesPerRead: 1024})
+	require.NoError(t, err)
+	assert.Len(t, r.Headers.Values("Cookie"), 20)
+
+	// Test: Buffer stops growing at MaxBufferBytes when limits are disabled
+	reader := NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: " + strings.Repeat("c", 5000) + "\r\n\r\n"))
+	reader.Limits = Limits{}
+	reader.MaxBufferBytes = 4096
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_BUFFER_FULL, err)
+
+	// Test: Large bodies don't need a large buffer
+	body := strings.Repeat("b", 10000)
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10000\r\n\r\n" + body))
+	reader.MaxBufferBytes = 1024
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, body, string(r.Body))
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Content-Length body streamed from the connection
+	body := strings.Repeat("upload", 1000)
+	reader := NewReader(&chunkReader{
+		data: "PUT /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6000\r\n\r\n" + body +
+			"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 100,
+	})
+	reader.StreamBody = true
+	bodySize := 0
+	reader.Hooks.OnBody = func(size int, _ time.Duration) { bodySize = size }
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: The next request can't be read before the body is consumed
+	_, err = reader.ReadRequest()
+	assert.Equal(t, ERROR_BODY_NOT_CONSUMED, err)
+
+	streamed, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, body, string(streamed))
+	assert.Equal(t, 6000, bodySize)
+
+	// Test: Bytes after the body belong to the next request
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	streamed, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Empty(t, streamed)
+
+	// Test: Chunked body with trailers streamed from the connection
+	reader = NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"6\r\n world\r\n" +
+			"0\r\n" +
+			"X-Checksum: 42\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	reader.StreamBody = true
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	streamed, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(streamed))
+	assert.Equal(t, "42", r.Trailers.Get("X-Checksum"))
+
+	// Test: Connection closed partway through a streamed body
+	reader = NewReader(strings.NewReader("PUT /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+	reader.StreamBody = true
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Test: Buffered requests expose their body through BodyReader too
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc"))
+	require.NoError(t, err)
+	streamed, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(streamed))
+}
+
+func TestDiscardBody(t *testing.T) {
+	data := "PUT /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n0123456789" +
+		"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	streamed := func() *Reader {
+		reader := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+		reader.StreamBody = true
+		return reader
+	}
+
+	// Test: Unread body within the limit is drained
+	reader := streamed()
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	require.NoError(t, DiscardBody(r, 10))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Partly read body only drains the rest
+	reader = streamed()
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadFull(r.BodyReader(), make([]byte, 4))
+	require.NoError(t, err)
+	require.NoError(t, DiscardBody(r, 6))
+
+	// Test: More body than the limit
+	reader = streamed()
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, ERROR_DISCARD_LIMIT_EXCEEDED, DiscardBody(r, 9))
+
+	// Test: Bodies already read in full need no draining
+	r, err = RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.NoError(t, DiscardBody(r, 0))
+}
+
+func TestReadRequests(t *testing.T) {
+	capture := "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /two HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /three HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: Every request is reported with the offset it starts at
+	targets := []string{}
+	offsets := []int64{}
+	err := ReadRequestsAt(strings.NewReader(capture), int64(len(capture)), func(req *Request, offset int64) error {
+		targets = append(targets, req.RequestLine.RequestTarget)
+		offsets = append(offsets, offset)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/one", "/two", "/three"}, targets)
+	assert.Equal(t, []int64{0, 38, 101}, offsets)
+	assert.Equal(t, "POST /two", capture[38:47])
+
+	// Test: Parse errors carry the offset of the bad request
+	err = ReadRequests(strings.NewReader(capture[:101]+"BROKEN\r\n\r\n"), func(req *Request, offset int64) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, ERROR_MALFORMED_REQUEST_LINE)
+	assert.Contains(t, err.Error(), "offset 101")
+
+	// Test: Errors from the callback stop the loop
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err = ReadRequests(strings.NewReader(capture), func(req *Request, offset int64) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestParserOptions(t *testing.T) {
+	// Test: Default options accept any method spelling but not leading blank lines
+	r, err := RequestFromReader(strings.NewReader("get / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "get", r.RequestLine.Method)
+	_, err = RequestFromReader(strings.NewReader("\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Equal(t, ERROR_MALFORMED_REQUEST_LINE, err)
+
+	// Test: Methods must always be tokens
+	for _, method := range []string{"G(E)T", "GE\x01T", "\"GET\""} {
+		_, err = RequestFromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		assert.ErrorIs(t, err, ERROR_MALFORMED_METHOD, method)
+	}
+
+	// Test: Only allowed methods get through
+	reader := NewReader(strings.NewReader("PATCH / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	reader.Options.AllowedMethods = []string{"GET", "HEAD"}
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_METHOD)
+
+	// Test: Without a body the rejected request is consumed whole and the next one parses
+	assert.True(t, reader.Resumable())
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "GET", r.RequestLine.Method)
+
+	// Test: With a body, or after errors in the framing, the stream can't be resumed
+	reader = NewReader(strings.NewReader("PATCH / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc"))
+	reader.Options.AllowedMethods = []string{"GET", "HEAD"}
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_METHOD)
+	assert.False(t, reader.Resumable())
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: x\r\n\r\n"))
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CONTENT_LENGTH)
+	assert.False(t, reader.Resumable())
+
+	r, err = RequestFromReader(strings.NewReader("PATCH / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "PATCH", r.RequestLine.Method)
+
+	// Test: Strict mode only accepts upper-case methods
+	reader = NewReader(strings.NewReader("get / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	reader.Options = StrictParserOptions
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_MALFORMED_METHOD)
+
+	// Test: Lenient mode skips blank lines before (and between) requests
+	reader = NewReader(strings.NewReader("\r\n\nPOST /one HTTP/1.1\nContent-Length: 2\n\nhi\r\n" +
+		"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n\r\n"))
+	reader.Options = LenientParserOptions
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/one", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hi", string(r.Body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/two", r.RequestLine.RequestTarget)
+
+	// Test: Trailing blank lines then a close is still a clean EOF
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPreserveHeaderCase(t *testing.T) {
+	data := "GET / HTTP/1.1\r\nx-custom-HEADER: 1\r\nHOST: localhost\r\nX-CUSTOM-header: 2\r\n\r\n"
+
+	// Test: Spellings are recorded, the first one winning
+	reader := NewReader(strings.NewReader(data))
+	reader.Options.PreserveHeaderCase = true
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "1, 2", r.Headers.Get("X-Custom-Header"))
+	assert.Equal(t, map[string]string{"X-Custom-Header": "x-custom-HEADER", "Host": "HOST"}, r.HeaderCase)
+	assert.Equal(t, headers.Headers{"x-custom-HEADER": {"1", "2"}, "HOST": {"localhost"}}, r.Headers.WithCase(r.HeaderCase))
+
+	// Test: Nothing is recorded by default
+	r, err = RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Nil(t, r.HeaderCase)
+}
+
+func TestURL(t *testing.T) {
+	// Test: Path, query and fragment are split and the path decoded
+	r, err := RequestFromReader(strings.NewReader("GET /search%20me?q=go%26more&page=2#top HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	u, err := r.URL()
+	require.NoError(t, err)
+	assert.Equal(t, "/search me", u.Path)
+	assert.Equal(t, "/search%20me", u.RawPath)
+	assert.Equal(t, "q=go%26more&page=2", u.RawQuery)
+	assert.Equal(t, "top", u.Fragment)
+
+	// Test: Encoded slashes decode, "+" is left alone in paths
+	r, err = RequestFromReader(strings.NewReader("GET /a%2Fb+c HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	u, err = r.URL()
+	require.NoError(t, err)
+	assert.Equal(t, "/a/b+c", u.Path)
+
+	// Test: Request.URL is parsed once and includes the authority
+	r, err = RequestFromReader(strings.NewReader("GET http://example.com/coffee?size=large HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	u, err = r.URL()
+	require.NoError(t, err)
+	assert.Equal(t, "http", u.Scheme)
+	assert.Equal(t, "example.com", u.Host)
+	assert.Equal(t, "/coffee", u.Path)
+	assert.Equal(t, "size=large", u.RawQuery)
+	again, _ := r.URL()
+	assert.Same(t, u, again)
+
+	// Test: Bad escapes
+	for _, target := range []string{"/%", "/%2", "/%zz", "/a%2"} {
+		r, err = RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		require.NoError(t, err, target)
+		_, err = r.URL()
+		assert.ErrorIs(t, err, ERROR_MALFORMED_TARGET, target)
+	}
+	assert.Equal(t, "example.com", r.Host())
+}
+
+func TestQuery(t *testing.T) {
+	// Test: Multiple values, both separators, decoding and bare keys
+	v := ParseQuery("tag=go&tag=http%2F1.1;q=hello+world&debug&=empty&bad=%zz")
+	assert.Equal(t, []string{"go", "http/1.1"}, v.All("tag"))
+	assert.Equal(t, "go", v.Get("tag"))
+	assert.Equal(t, "hello world", v.Get("q"))
+	assert.Equal(t, []string{""}, v.All("debug"))
+	assert.Equal(t, "empty", v.Get(""))
+	assert.Equal(t, "%zz", v.Get("bad"))
+	assert.Equal(t, "", v.Get("missing"))
+	assert.Nil(t, v.All("missing"))
+
+	// Test: Empty pairs are skipped
+	assert.Equal(t, Values{"a": {"1"}}, ParseQuery("&&a=1;&"))
+
+	// Test: Request.Query reads the target, ignoring any fragment
+	r, err := RequestFromReader(strings.NewReader("GET /search?q=coffee&size=large#top HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "coffee", r.Query().Get("q"))
+	assert.Equal(t, "large", r.Query().Get("size"))
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Query())
+}
+
+func TestTargetForms(t *testing.T) {
+	// Test: Each form is recognised for the methods that may use it
+	for _, tc := range []struct {
+		line string
+		form TargetForm
+	}{
+		{"GET /index.html?x=1 HTTP/1.1", TargetOrigin},
+		{"GET http://example.com/index.html HTTP/1.1", TargetAbsolute},
+		{"GET svn+ssh://example.com HTTP/1.1", TargetAbsolute},
+		{"CONNECT example.com:443 HTTP/1.1", TargetAuthority},
+		{"CONNECT [::1]:8443 HTTP/1.1", TargetAuthority},
+		{"OPTIONS * HTTP/1.1", TargetAsterisk},
+	} {
+		rl, _, err := ParseRequestLine([]byte(tc.line + "\r\n"))
+		require.NoError(t, err, tc.line)
+		assert.Equal(t, tc.form, rl.TargetForm, tc.line)
+	}
+
+	// Test: Forms used with the wrong method, or no form at all
+	for _, line := range []string{
+		"GET * HTTP/1.1",
+		"CONNECT /index.html HTTP/1.1",
+		"CONNECT example.com HTTP/1.1",
+		"CONNECT http://example.com:443 HTTP/1.1",
+		"GET example.com:443 HTTP/1.1",
+		"GET index.html HTTP/1.1",
+		"GET 1http://example.com/ HTTP/1.1",
+	} {
+		_, _, err := ParseRequestLine([]byte(line + "\r\n"))
+		assert.ErrorIs(t, err, ERROR_MALFORMED_TARGET, line)
+	}
+
+	// Test: Absolute-form targets split like origin-form ones
+	u, err := url.Parse("http://example.com:8080/a%20b?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, "/a b", u.Path)
+	assert.Equal(t, "q=1", u.RawQuery)
+	u, err = url.Parse("http://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "/", u.Path)
+	u, err = url.Parse("http://example.com?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, "/", u.Path)
+	assert.Equal(t, "q=1", u.RawQuery)
+}
+
+func TestHost(t *testing.T) {
+	// Test: Host header, and the authority of the target overriding it
+	for _, tc := range []struct {
+		raw  string
+		host string
+	}{
+		{"GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", "localhost:42069"},
+		{"GET http://example.com:8080/a?b HTTP/1.1\r\nHost: other\r\n\r\n", "example.com:8080"},
+		{"GET http://user:pw@example.com HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com"},
+		{"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n", "example.com:443"},
+		{"GET / HTTP/1.1\r\nHost: [::1]:80\r\n\r\n", "[::1]:80"},
+	} {
+		r, err := RequestFromReader(strings.NewReader(tc.raw))
+		require.NoError(t, err, tc.raw)
+		assert.Equal(t, tc.host, r.Host(), tc.raw)
+	}
+
+	// Test: Repeated or invalid Host headers
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n"))
+	assert.Equal(t, ERROR_DUPLICATE_HOST, err)
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a.example/evil\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_INVALID_HOST)
+
+	// Test: Missing Host is an error unless allowed
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	assert.Equal(t, ERROR_MISSING_HOST, err)
+	reader := NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	reader.Options.AllowMissingHost = true
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "", r.Host())
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 requests parse, without a Host
+	r, err := RequestFromReader(strings.NewReader("POST /form HTTP/1.0\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.True(t, r.RequestLine.ValidHTTP())
+	assert.True(t, r.IsHTTP10())
+	assert.Equal(t, "hi", string(r.Body))
+
+	// Test: No chunked bodies in HTTP/1.0
+	_, err = RequestFromReader(strings.NewReader("POST /form HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_TRANSFER_ENCODING)
+
+	// Test: Other well-formed versions are unsupported, malformed ones are malformed
+	_, _, err = ParseRequestLine([]byte("GET / HTTP/2.0\r\n"))
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_HTTP_VERSION)
+	for _, version := range []string{"HTTP/1", "HTTP/1.1.1", "HTTP/a.b", "http/1.1"} {
+		_, _, err = ParseRequestLine([]byte("GET / " + version + "\r\n"))
+		assert.Equal(t, ERROR_MALFORMED_REQUEST_LINE, err, version)
+	}
+}
+
+func TestBodySinks(t *testing.T) {
+	raw := "POST /small HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST /big HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world" +
+		"POST /chunked HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"POST /typed HTTP/1.1\r\nHost: localhost\r\nContent-Type: Application/Octet-Stream; x=1\r\nContent-Length: 2\r\n\r\nhi" +
+		"GET /empty HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	dir := t.TempDir()
+	reader := NewReader(&chunkReader{data: raw, numBytesPerRead: 3})
+	reader.BodySinks = &BodySinkPolicy{
+		MaxMemoryBytes:   8,
+		TempDir:          dir,
+		FileContentTypes: []string{"application/octet-stream"},
+	}
+	read := func(path string, inFile bool) {
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, path, r.RequestLine.RequestTarget)
+		assert.Empty(t, r.Body)
+
+		_, isFile := r.sink.(*fileSink)
+		assert.Equal(t, inFile, isFile, path)
+		files := 0
+		if inFile {
+			files = 1
+		}
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, files, path)
+
+		// The handle is seekable, so it can be read twice
+		first, err := io.ReadAll(r.BodyFile())
+		require.NoError(t, err)
+		_, err = r.BodyFile().Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		second, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, first, second, path)
+
+		// Closing releases the temp file
+		require.NoError(t, r.Close())
+		entries, err = os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries, path)
+	}
+
+	// Test: Small bodies stay in memory, large and chunked ones go to a temp file
+	read("/small", false)
+	read("/big", true)
+	read("/chunked", true)
+
+	// Test: Listed content types always go to a temp file
+	read("/typed", true)
+
+	// Test: No body, nothing to store
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Nil(t, r.BodyFile())
+	require.NoError(t, r.Close())
+
+	// Test: A user-provided sink, and falling through when it returns nil
+	var custom *memorySink
+	reader = NewReader(strings.NewReader("POST /mine HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi" +
+		"POST /default HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi"))
+	reader.BodySinks = &BodySinkPolicy{New: func(req *Request) (BodySink, error) {
+		if req.RequestLine.RequestTarget != "/mine" {
+			return nil, nil
+		}
+		custom = &memorySink{}
+		return custom, nil
+	}, MaxMemoryBytes: 8}
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", custom.buf.String())
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.BodyFile())
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(body))
+
+	// Test: Sink failures are ERROR_BODY_SINK, body parse errors are not
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi"))
+	reader.BodySinks = &BodySinkPolicy{TempDir: filepath.Join(t.TempDir(), "missing")}
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_BODY_SINK)
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	reader.BodySinks = &BodySinkPolicy{}
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+	assert.NotErrorIs(t, err, ERROR_BODY_SINK)
+}
@@ -0,0 +1,194 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// ERROR_BODY_SINK wraps a failure of the BodySink itself (creating it,
+// writing to it, or opening the stored body), as opposed to a malformed body.
+// It's the server's fault, and the wrapped error may name files on its disk.
+var ERROR_BODY_SINK = fmt.Errorf("ERROR: Body Sink Failed")
+
+// BodySink stores a request body as ReadRequest reads it, so an upload
+// doesn't have to fit in Request.Body. See BodySinkPolicy for how one is
+// chosen for each request.
+type BodySink interface {
+	// Write receives the decoded body, in order
+	io.Writer
+
+	// Body is called once the whole body has been written and returns it
+	// for the handler, positioned at the start
+	Body() (io.ReadSeeker, error)
+
+	// Close releases the storage (e.g. removes a temp file).
+	// The handle returned by Body can't be used afterwards.
+	Close() error
+}
+
+// memorySink keeps the body in a growing byte slice
+type memorySink struct {
+	buf bytes.Buffer
+}
+
+// NewMemorySink returns a BodySink that keeps the body in memory
+func NewMemorySink() BodySink {
+	return &memorySink{}
+}
+
+func (m *memorySink) Write(p []byte) (int, error) {
+	return m.buf.Write(p)
+}
+
+func (m *memorySink) Body() (io.ReadSeeker, error) {
+	return bytes.NewReader(m.buf.Bytes()), nil
+}
+
+func (m *memorySink) Close() error {
+	m.buf = bytes.Buffer{}
+	return nil
+}
+
+// fileSink spools the body to a temp file that's removed on Close
+type fileSink struct {
+	file *os.File
+}
+
+// NewFileSink returns a BodySink that writes the body to a new temp file in
+// dir (os.TempDir() when empty). The file is removed when the sink is closed.
+func NewFileSink(dir string) (BodySink, error) {
+	file, err := os.CreateTemp(dir, "httpfromtcp-body-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (f *fileSink) Write(p []byte) (int, error) {
+	return f.file.Write(p)
+}
+
+func (f *fileSink) Body() (io.ReadSeeker, error) {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return f.file, nil
+}
+
+func (f *fileSink) Close() error {
+	closeErr := f.file.Close()
+	if err := os.Remove(f.file.Name()); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// BodySinkPolicy picks where each request body is stored when set as
+// Reader.BodySinks. In order:
+//  1. New, if set and it returns a sink
+//  2. a temp file for the media types in FileContentTypes
+//  3. memory for a Content-Length up to MaxMemoryBytes
+//  4. a temp file for everything else, including chunked bodies whose
+//     length isn't known up front
+type BodySinkPolicy struct {
+	// New lets the caller provide the sink, e.g. one writing straight to
+	// object storage. Returning a nil sink (and nil error) falls through to
+	// the rules below; an error fails the request.
+	New func(req *Request) (BodySink, error)
+
+	// FileContentTypes are media types always spooled to a file whatever
+	// their size. Example: []string{"application/octet-stream"}
+	FileContentTypes []string
+
+	// MaxMemoryBytes is the largest Content-Length kept in memory
+	MaxMemoryBytes int64
+
+	// TempDir is where temp files are created, os.TempDir() when empty
+	TempDir string
+}
+
+// sink creates the BodySink for req, whose headers have been parsed
+func (p *BodySinkPolicy) sink(req *Request) (BodySink, error) {
+	if p.New != nil {
+		sink, err := p.New(req)
+		if err != nil || sink != nil {
+			return sink, err
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Headers.Get("Content-Type"))
+	for _, t := range p.FileContentTypes {
+		if strings.EqualFold(mediaType, t) {
+			return NewFileSink(p.TempDir)
+		}
+	}
+
+	chunked, _ := isChunked(req.Headers)
+	if !chunked && int64(req.bodyLength) <= p.MaxMemoryBytes {
+		return NewMemorySink(), nil
+	}
+	return NewFileSink(p.TempDir)
+}
+
+// sinkBody reads the rest of request's body into a sink picked by
+// BodySinks and keeps the sink's handle for BodyFile
+func (rr *Reader) sinkBody(request *Request) error {
+	sink, err := rr.BodySinks.sink(request)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ERROR_BODY_SINK, err)
+	}
+
+	// Body bytes that arrived with the headers go first, same as when streaming
+	body := &bodyReader{reader: rr, request: request, pending: request.Body}
+	request.Body = nil
+	if _, err := io.Copy(sinkWriter{sink}, body); err != nil {
+		sink.Close()
+		return err
+	}
+
+	file, err := sink.Body()
+	if err != nil {
+		sink.Close()
+		return fmt.Errorf("%w: %w", ERROR_BODY_SINK, err)
+	}
+	request.sink = sink
+	request.bodyFile = file
+	return nil
+}
+
+// sinkWriter wraps the sink's write errors in ERROR_BODY_SINK, so they can be
+// told apart from the body's own parse errors coming out of the same io.Copy
+type sinkWriter struct {
+	sink BodySink
+}
+
+func (w sinkWriter) Write(p []byte) (int, error) {
+	n, err := w.sink.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("%w: %w", ERROR_BODY_SINK, err)
+	}
+	return n, nil
+}
+
+// BodyFile returns the body stored by the Reader's BodySinks, seekable and
+// positioned at the start, or nil if the request was read without
+// BodySinks or has no body
+func (r *Request) BodyFile() io.ReadSeeker {
+	return r.bodyFile
+}
+
+// Close releases the BodySink holding the body (removing any temp file).
+// Requests read without BodySinks have nothing to release.
+func (r *Request) Close() error {
+	if r.sink == nil {
+		return nil
+	}
+	sink := r.sink
+	r.sink = nil
+	r.bodyFile = nil
+	return sink.Close()
+}
@@ -0,0 +1,132 @@
+package requesttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+)
+
+// Assertions on parsed Requests for parser and handler tests.
+// Like testify's assert package they report a failure with t.Errorf,
+// keep the test running, and return whether the assertion passed.
+
+// TB is the part of testing.TB the assertions use, so any *testing.T or
+// *testing.B works, as does a fake that records failures
+type TB interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// MatchesMethod asserts that the request method is exactly method
+func MatchesMethod(t TB, r *request.Request, method string) bool {
+	t.Helper()
+	if !notNil(t, r) {
+		return false
+	}
+	if r.RequestLine.Method != method {
+		t.Errorf("expected method %q, got %q", method, r.RequestLine.Method)
+		return false
+	}
+	return true
+}
+
+// MatchesTarget asserts that the raw request target is exactly target
+func MatchesTarget(t TB, r *request.Request, target string) bool {
+	t.Helper()
+	if !notNil(t, r) {
+		return false
+	}
+	if r.RequestLine.RequestTarget != target {
+		t.Errorf("expected request target %q, got %q", target, r.RequestLine.RequestTarget)
+		return false
+	}
+	return true
+}
+
+// MatchesVersion asserts that the HTTP version is exactly version (e.g. "1.1")
+func MatchesVersion(t TB, r *request.Request, version string) bool {
+	t.Helper()
+	if !notNil(t, r) {
+		return false
+	}
+	if r.RequestLine.HttpVersion != version {
+		t.Errorf("expected HTTP version %q, got %q", version, r.RequestLine.HttpVersion)
+		return false
+	}
+	return true
+}
+
+// MatchesRequestLine asserts the method, target and version in one call
+func MatchesRequestLine(t TB, r *request.Request, method, target, version string) bool {
+	t.Helper()
+	ok := MatchesMethod(t, r, method)
+	ok = MatchesTarget(t, r, target) && ok
+	ok = MatchesVersion(t, r, version) && ok
+	return ok
+}
+
+// HasHeader asserts that the request has the header name (case-insensitive)
+// with exactly value; a repeated field is compared comma-joined, see Headers.Get
+// Example: HasHeader(t, r, "content-type", "application/json")
+func HasHeader(t TB, r *request.Request, name, value string) bool {
+	t.Helper()
+	if !notNil(t, r) {
+		return false
+	}
+	if !r.Headers.Has(name) {
+		t.Errorf("expected header %s, it is missing", name)
+		return false
+	}
+	if got := r.Headers.Get(name); got != value {
+		t.Errorf("expected header %s to be %q, got %q", name, value, got)
+		return false
+	}
+	return true
+}
+
+// BodyJSONEquals asserts that the request body and expected are the same
+// JSON document, ignoring whitespace and the order of object keys
+// Example: BodyJSONEquals(t, r, `{"name": "coffee", "size": "large"}`)
+func BodyJSONEquals(t TB, r *request.Request, expected string) bool {
+	t.Helper()
+	if !notNil(t, r) {
+		return false
+	}
+
+	var want, got any
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		t.Errorf("expected JSON is invalid: %v", err)
+		return false
+	}
+	if err := json.Unmarshal(r.Body, &got); err != nil {
+		t.Errorf("expected a JSON body, got %q: %v", r.Body, err)
+		return false
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected JSON body %s, got %s", compact(expected), compact(string(r.Body)))
+		return false
+	}
+	return true
+}
+
+// compact strips the insignificant whitespace from a valid JSON document,
+// so failure messages line up
+func compact(doc string) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(doc)); err != nil {
+		return doc
+	}
+	return b.String()
+}
+
+// notNil fails the test if the request is nil
+func notNil(t TB, r *request.Request) bool {
+	t.Helper()
+	if r == nil {
+		t.Errorf("expected a parsed request, got nil")
+		return false
+	}
+	return true
+}
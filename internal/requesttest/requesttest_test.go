@@ -0,0 +1,55 @@
+package requesttest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a TB that records failures instead of failing the test
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMatchers(t *testing.T) {
+	body := `{"name": "coffee", "size": "large"}`
+	r, err := request.RequestFromReader(strings.NewReader("POST /coffee HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	require.NoError(t, err)
+
+	// Test: Matching request line, header and body pass
+	assert.True(t, MatchesRequestLine(t, r, "POST", "/coffee", "1.1"))
+	assert.True(t, HasHeader(t, r, "content-type", "application/json"))
+	assert.True(t, BodyJSONEquals(t, r, `{"size":"large","name":"coffee"}`))
+
+	// Test: Each mismatch is reported as one failure
+	for _, check := range []func(TB) bool{
+		func(tb TB) bool { return MatchesMethod(tb, r, "GET") },
+		func(tb TB) bool { return MatchesTarget(tb, r, "/tea") },
+		func(tb TB) bool { return MatchesVersion(tb, nil, "1.1") },
+		func(tb TB) bool { return HasHeader(tb, r, "Content-Type", "text/plain") },
+		func(tb TB) bool { return HasHeader(tb, r, "Accept", "") },
+		func(tb TB) bool { return BodyJSONEquals(tb, r, `{"name":"tea","size":"large"}`) },
+		func(tb TB) bool { return BodyJSONEquals(tb, r, `not json`) },
+	} {
+		rec := &recorder{}
+		assert.False(t, check(rec))
+		assert.Len(t, rec.errors, 1, rec.errors)
+	}
+
+	// Test: MatchesRequestLine reports every part that differs
+	rec := &recorder{}
+	assert.False(t, MatchesRequestLine(rec, r, "GET", "/tea", "1.1"))
+	assert.Equal(t, []string{`expected method "GET", got "POST"`, `expected request target "/tea", got "/coffee"`}, rec.errors)
+}
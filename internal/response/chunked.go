@@ -0,0 +1,123 @@
+package response
+
+import (
+	"fmt"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+)
+
+// ERROR_UNDECLARED_TRAILER is returned by WriteTrailers for a field the
+// headers didn't list in "Trailer"
+var ERROR_UNDECLARED_TRAILER = fmt.Errorf("ERROR: Trailer field not declared in Trailer header")
+
+// WriteChunkedBody writes p as a single chunk of a chunked body.
+// The headers must have announced "Transfer-Encoding: chunked" (or UseChunked
+// been called), otherwise it fails with ERROR_WRONG_BODY_FRAMING.
+// Writing an empty p does nothing, since a zero-length chunk would end the body.
+// Returns: (bytes of p written, error)
+//
+// Wire format for "hello":
+//
+//	5\r\n
+//	hello\r\n
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if err := w.checkChunkedBody(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.http10 {
+		n, err := w.writer.Write(p)
+		w.bodyWritten += int64(n)
+		return n, err
+	}
+
+	// Build the whole chunk first so it goes out in one write
+	chunk := fmt.Appendf(nil, "%x\r\n", len(p))
+	chunk = append(chunk, p...)
+	chunk = append(chunk, rn...)
+
+	if _, err := w.writer.Write(chunk); err != nil {
+		return 0, err
+	}
+	w.bodyWritten += int64(len(p))
+	return len(p), nil
+}
+
+// WriteChunkedBodyDone ends a chunked body with the zero-length last chunk
+// followed by the empty line that closes the (empty) trailer section.
+// Nothing can be written after it.
+// Returns: (bytes written, error)
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if err := w.checkChunkedBody(); err != nil {
+		return 0, err
+	}
+	if w.http10 {
+		w.state = StateDone
+		return 0, nil
+	}
+
+	n, err := w.writer.Write([]byte("0\r\n\r\n"))
+	if err != nil {
+		return n, err
+	}
+
+	w.state = StateDone
+	return n, nil
+}
+
+// WriteTrailers ends a chunked body like WriteChunkedBodyDone, but sends the
+// fields of h in the trailer section after the last chunk. This is how values
+// only known once the body has been streamed, like a checksum, reach the client.
+// Every field must have been announced in the "Trailer" header, so the client
+// knows to expect it. Nothing can be written after it.
+//
+// Wire format for Trailer: X-Content-Length with a 5 byte body:
+//
+//	0\r\n
+//	X-Content-Length: 5\r\n
+//	\r\n
+func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if err := w.checkChunkedBody(); err != nil {
+		return err
+	}
+
+	declared := map[string]bool{}
+	for _, name := range w.headers.List("Trailer") {
+		declared[headers.CanonicalName(name)] = true
+	}
+	for name := range h {
+		if !declared[headers.CanonicalName(name)] {
+			return fmt.Errorf("%w: %s", ERROR_UNDECLARED_TRAILER, name)
+		}
+	}
+
+	if w.http10 {
+		w.state = StateDone
+		return nil
+	}
+
+	b := []byte("0\r\n")
+	b = appendFields(b, h)
+	b = append(b, rn...)
+
+	if _, err := w.writer.Write(b); err != nil {
+		return err
+	}
+
+	w.state = StateDone
+	return nil
+}
+
+// checkChunkedBody is checkBody that also refuses chunks unless the headers
+// announced a chunked body, with UseChunked or "Transfer-Encoding: chunked"
+func (w *Writer) checkChunkedBody() error {
+	if err := w.checkBody(); err != nil {
+		return err
+	}
+	if !w.chunkedBody {
+		return ERROR_WRONG_BODY_FRAMING
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package response
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+)
+
+// Errors returned when taking over the connection
+var ERROR_NOT_HIJACKABLE = fmt.Errorf("ERROR: Writer does not support Hijack")
+var ERROR_HIJACKED = fmt.Errorf("ERROR: Connection has been hijacked")
+
+// Hijacker is implemented by what a Writer writes to when the connection
+// underneath can be taken over, see Writer.Hijack. The server's writer does.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// Hijack hands the connection over to the handler, e.g. to speak another
+// protocol after a 101 Switching Protocols response. The returned reader
+// starts with any bytes the server had already read past the request, so
+// nothing the client sent right after it is lost.
+// From then on the handler owns the connection: the server sets no more
+// deadlines, won't read another request from it and won't close it, and
+// every write through w fails with ERROR_HIJACKED.
+// Returns ERROR_NOT_HIJACKABLE if w doesn't write to a Hijacker.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.state == StateHijacked {
+		return nil, nil, ERROR_HIJACKED
+	}
+	hj, ok := w.writer.(Hijacker)
+	if !ok {
+		return nil, nil, ERROR_NOT_HIJACKABLE
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.state = StateHijacked
+	return conn, rw, nil
+}
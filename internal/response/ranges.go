@@ -0,0 +1,34 @@
+package response
+
+import "fmt"
+
+// ERROR_INVALID_RANGE is returned for a byte range that doesn't fit inside the resource
+var ERROR_INVALID_RANGE = fmt.Errorf("ERROR: Range not satisfiable")
+
+// ValidateRange checks that the inclusive byte range start-end lies inside a
+// resource of size bytes (RFC 9110 section 14.1.1): 0 <= start <= end < size.
+// A handler serving a range it computed itself should check it before
+// sending 206, and answer 416 with UnsatisfiableContentRange otherwise.
+func ValidateRange(start, end, size int64) error {
+	if start < 0 || start > end || end >= size {
+		return fmt.Errorf("%w: bytes %d-%d of %d", ERROR_INVALID_RANGE, start, end, size)
+	}
+	return nil
+}
+
+// ContentRange builds the Content-Range value for a 206 Partial Content response
+// carrying bytes start through end (inclusive) of a resource of size bytes.
+// Example: ContentRange(0, 499, 1234) → "bytes 0-499/1234"
+func ContentRange(start, end, size int64) (string, error) {
+	if err := ValidateRange(start, end, size); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("bytes %d-%d/%d", start, end, size), nil
+}
+
+// UnsatisfiableContentRange builds the Content-Range value sent with
+// 416 Range Not Satisfiable, telling the client the resource's real size
+// Example: UnsatisfiableContentRange(1234) → "bytes */1234"
+func UnsatisfiableContentRange(size int64) string {
+	return fmt.Sprintf("bytes */%d", size)
+}
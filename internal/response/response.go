@@ -0,0 +1,433 @@
+package response
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jrooke/httpfromtcp/internal/clock"
+	"github.com/jrooke/httpfromtcp/internal/headers"
+)
+
+// StatusCode is the numeric HTTP status sent in the status line
+// The constants cover the codes defined in RFC 9110 section 15 that a server commonly sends.
+type StatusCode int
+
+const (
+	// Informational 1xx
+	StatusContinue           StatusCode = 100
+	StatusSwitchingProtocols StatusCode = 101
+
+	// Successful 2xx
+	StatusOK             StatusCode = 200
+	StatusCreated        StatusCode = 201
+	StatusAccepted       StatusCode = 202
+	StatusNoContent      StatusCode = 204
+	StatusPartialContent StatusCode = 206
+
+	// Redirection 3xx
+	StatusMovedPermanently  StatusCode = 301
+	StatusFound             StatusCode = 302
+	StatusSeeOther          StatusCode = 303
+	StatusNotModified       StatusCode = 304
+	StatusTemporaryRedirect StatusCode = 307
+	StatusPermanentRedirect StatusCode = 308
+
+	// Client error 4xx
+	StatusBadRequest                  StatusCode = 400
+	StatusUnauthorized                StatusCode = 401
+	StatusForbidden                   StatusCode = 403
+	StatusNotFound                    StatusCode = 404
+	StatusMethodNotAllowed            StatusCode = 405
+	StatusRequestTimeout              StatusCode = 408
+	StatusConflict                    StatusCode = 409
+	StatusGone                        StatusCode = 410
+	StatusLengthRequired              StatusCode = 411
+	StatusPreconditionFailed          StatusCode = 412
+	StatusContentTooLarge             StatusCode = 413
+	StatusURITooLong                  StatusCode = 414
+	StatusUnsupportedMediaType        StatusCode = 415
+	StatusRangeNotSatisfiable         StatusCode = 416
+	StatusExpectationFailed           StatusCode = 417
+	StatusUpgradeRequired             StatusCode = 426
+	StatusTooManyRequests             StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
+
+	// Server error 5xx
+	StatusInternalServerError     StatusCode = 500
+	StatusNotImplemented          StatusCode = 501
+	StatusBadGateway              StatusCode = 502
+	StatusServiceUnavailable      StatusCode = 503
+	StatusGatewayTimeout          StatusCode = 504
+	StatusHTTPVersionNotSupported StatusCode = 505
+)
+
+// reasonPhrases maps status codes to the text sent after the code
+var reasonPhrases = map[StatusCode]string{
+	StatusContinue:                    "Continue",
+	StatusSwitchingProtocols:          "Switching Protocols",
+	StatusOK:                          "OK",
+	StatusCreated:                     "Created",
+	StatusAccepted:                    "Accepted",
+	StatusNoContent:                   "No Content",
+	StatusPartialContent:              "Partial Content",
+	StatusMovedPermanently:            "Moved Permanently",
+	StatusFound:                       "Found",
+	StatusSeeOther:                    "See Other",
+	StatusNotModified:                 "Not Modified",
+	StatusTemporaryRedirect:           "Temporary Redirect",
+	StatusPermanentRedirect:           "Permanent Redirect",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusConflict:                    "Conflict",
+	StatusGone:                        "Gone",
+	StatusLengthRequired:              "Length Required",
+	StatusPreconditionFailed:          "Precondition Failed",
+	StatusContentTooLarge:             "Content Too Large",
+	StatusURITooLong:                  "URI Too Long",
+	StatusUnsupportedMediaType:        "Unsupported Media Type",
+	StatusRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusExpectationFailed:           "Expectation Failed",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalServerError:         "Internal Server Error",
+	StatusNotImplemented:              "Not Implemented",
+	StatusBadGateway:                  "Bad Gateway",
+	StatusServiceUnavailable:          "Service Unavailable",
+	StatusGatewayTimeout:              "Gateway Timeout",
+	StatusHTTPVersionNotSupported:     "HTTP Version Not Supported",
+}
+
+// ReasonPhrase returns the reason phrase for the code, or "" if it isn't known
+// Example: StatusNotFound.ReasonPhrase() → "Not Found"
+func (c StatusCode) ReasonPhrase() string {
+	return reasonPhrases[c]
+}
+
+// TimeFormat is the format of the Date header (RFC 9110 section 5.6.7), always in GMT
+// Example: "Sun, 06 Nov 1994 08:49:37 GMT"
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// GetDefaultHeaders returns the headers most responses need for a body of contentLen bytes:
+// Content-Length, Connection: close, Content-Type: text/plain and the current Date.
+// Change any of them with Set or Delete before passing the result to WriteHeaders.
+// Example: h := GetDefaultHeaders(len(page)); h.Set("Content-Type", "text/html")
+func GetDefaultHeaders(contentLen int) headers.Headers {
+	return GetDefaultHeadersWithClock(contentLen, clock.System)
+}
+
+// GetDefaultHeadersWithClock is GetDefaultHeaders with the Date taken from c
+func GetDefaultHeadersWithClock(contentLen int, c clock.Clock) headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(contentLen))
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/plain")
+	h.Set("Date", c.Now().UTC().Format(TimeFormat))
+	return h
+}
+
+// Custom writerState type tracking which part of the response comes next
+type writerState string
+
+const (
+	StateStatusLine writerState = "status-line"
+	StateHeaders    writerState = "headers"
+	StateBody       writerState = "body"
+	StateDone       writerState = "done"
+
+	// StateHijacked means the handler took over the connection, see Hijack
+	StateHijacked writerState = "hijacked"
+)
+
+// Errors returned when the response parts are written out of order
+var ERROR_STATUS_LINE_ALREADY_WRITTEN = fmt.Errorf("ERROR: Status line already written")
+var ERROR_HEADERS_BEFORE_STATUS_LINE = fmt.Errorf("ERROR: Headers written before status line")
+var ERROR_HEADERS_ALREADY_WRITTEN = fmt.Errorf("ERROR: Headers already written")
+var ERROR_BODY_BEFORE_HEADERS = fmt.Errorf("ERROR: Body written before headers")
+var ERROR_BODY_ALREADY_DONE = fmt.Errorf("ERROR: Body already finished")
+
+// Errors returned when the body framing chosen with SetContentLength or UseChunked is broken
+var ERROR_FRAMING_ALREADY_SET = fmt.Errorf("ERROR: Content-Length and chunked are mutually exclusive")
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("ERROR: Content-Length can't be negative")
+var ERROR_BODY_EXCEEDS_CONTENT_LENGTH = fmt.Errorf("ERROR: Body longer than Content-Length")
+var ERROR_WRONG_BODY_FRAMING = fmt.Errorf("ERROR: Body written with the wrong framing")
+
+var rn = []byte("\r\n")
+
+// Writer writes an HTTP/1.1 response to an underlying io.Writer
+// (usually a net.Conn). The parts must be written in order:
+// WriteStatusLine → WriteHeaders → WriteBody (any number of times),
+// or WriteChunkedBody (any number of times) → WriteChunkedBodyDone (or WriteTrailers)
+// for bodies of unknown length.
+type Writer struct {
+	writer io.Writer
+	state  writerState
+
+	// headers is what was passed to WriteHeaders, nil until then
+	headers headers.Headers
+
+	// framing is the body framing chosen with SetContentLength or UseChunked,
+	// contentLength and bodyWritten enforce a chosen length, bodyWritten is also BodyBytes
+	framing       framing
+	contentLength int64
+	bodyWritten   int64
+
+	// chunkedBody is whether WriteHeaders announced a chunked body, which
+	// WriteChunkedBody needs. Kept apart from headers, which lose
+	// Transfer-Encoding when DowngradeToHTTP10 applies.
+	chunkedBody bool
+
+	// http10 is set by DowngradeToHTTP10
+	http10 bool
+
+	// statusCode is what WriteStatusLine wrote, 0 until then
+	statusCode StatusCode
+}
+
+// framing is how the end of the body is signalled
+type framing int
+
+const (
+	framingUnset framing = iota
+	framingLength
+	framingChunked
+)
+
+// Constructor function to create a Writer that starts at the status line
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		writer: w,
+		state:  StateStatusLine,
+	}
+}
+
+// SetContentLength declares a body of exactly n bytes. WriteHeaders will send
+// "Content-Length: n" (and no Transfer-Encoding) whatever the headers say, and
+// WriteBody refuses to write past n bytes. Must be called before WriteHeaders,
+// and not after UseChunked.
+func (w *Writer) SetContentLength(n int64) error {
+	if err := w.checkFraming(framingLength); err != nil {
+		return err
+	}
+	if n < 0 {
+		return ERROR_INVALID_CONTENT_LENGTH
+	}
+	w.framing = framingLength
+	w.contentLength = n
+	return nil
+}
+
+// UseChunked declares a body of unknown length. WriteHeaders will send
+// "Transfer-Encoding: chunked" (and no Content-Length) whatever the headers say,
+// and the body must be written with WriteChunkedBody. Must be called before
+// WriteHeaders, and not after SetContentLength.
+func (w *Writer) UseChunked() error {
+	if err := w.checkFraming(framingChunked); err != nil {
+		return err
+	}
+	w.framing = framingChunked
+	return nil
+}
+
+// DowngradeToHTTP10 tells the writer the client only speaks HTTP/1.0, which
+// has no chunked coding. A chunked response is sent without
+// "Transfer-Encoding: chunked" and with "Connection: close" instead; chunks go
+// out as raw bytes and the end of the body is marked by closing the connection.
+// Trailers can't be sent and are dropped. Must be called before WriteHeaders.
+func (w *Writer) DowngradeToHTTP10() {
+	w.http10 = true
+}
+
+// checkFraming returns an error unless the body framing can still be set to f
+func (w *Writer) checkFraming(f framing) error {
+	if w.state == StateBody || w.state == StateDone {
+		return ERROR_HEADERS_ALREADY_WRITTEN
+	}
+	if w.framing != framingUnset && w.framing != f {
+		return ERROR_FRAMING_ALREADY_SET
+	}
+	return nil
+}
+
+// WriteStatusLine writes the status line for the given code
+// Example: 200 → "HTTP/1.1 200 OK\r\n"
+// Codes without a known reason phrase are written with an empty one, which is allowed.
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if w.state == StateHijacked {
+		return ERROR_HIJACKED
+	}
+	if w.state != StateStatusLine {
+		return ERROR_STATUS_LINE_ALREADY_WRITTEN
+	}
+
+	_, err := fmt.Fprintf(w.writer, "HTTP/1.1 %d %s\r\n", statusCode, statusCode.ReasonPhrase())
+	if err != nil {
+		return err
+	}
+
+	w.statusCode = statusCode
+	w.state = StateHeaders
+	return nil
+}
+
+// WriteHeaders writes every header as "Name: value\r\n" followed
+// by the empty line that ends the header section.
+// Headers are written in sorted order so output is deterministic.
+// A framing chosen with SetContentLength or UseChunked replaces any
+// Content-Length or Transfer-Encoding in h.
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	switch w.state {
+	case StateStatusLine:
+		return ERROR_HEADERS_BEFORE_STATUS_LINE
+	case StateBody, StateDone:
+		return ERROR_HEADERS_ALREADY_WRITTEN
+	case StateHijacked:
+		return ERROR_HIJACKED
+	}
+
+	// Apply an explicit framing choice on a copy, leaving the caller's map alone
+	switch w.framing {
+	case framingLength:
+		h = copyHeaders(h)
+		h.Delete("Transfer-Encoding")
+		h.Set("Content-Length", strconv.FormatInt(w.contentLength, 10))
+	case framingChunked:
+		h = copyHeaders(h)
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	// An HTTP/1.0 client can't decode chunks, so the body runs until we close
+	w.chunkedBody = w.chunked(h)
+	if w.http10 && w.chunkedBody {
+		h = copyHeaders(h)
+		h.Delete("Transfer-Encoding")
+		h.Set("Connection", "close")
+	}
+
+	b := appendFields(nil, h)
+	b = append(b, rn...)
+
+	if _, err := w.writer.Write(b); err != nil {
+		return err
+	}
+
+	w.headers = h
+	w.state = StateBody
+	return nil
+}
+
+// chunked reports whether h announces a chunked body
+func (w *Writer) chunked(h headers.Headers) bool {
+	return w.framing == framingChunked || strings.EqualFold(h.Get("Transfer-Encoding"), "chunked")
+}
+
+// copyHeaders returns a copy of h with canonical names
+func copyHeaders(h headers.Headers) headers.Headers {
+	out := headers.NewHeaders()
+	for name, values := range h {
+		for _, value := range values {
+			out.Add(name, value)
+		}
+	}
+	return out
+}
+
+// appendFields appends every field of h to b as "Name: value\r\n", sorted by name.
+// A name with several values gets one line per value, since some fields
+// (Set-Cookie) can't be combined into a comma-separated list.
+func appendFields(b []byte, h headers.Headers) []byte {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range h[name] {
+			b = fmt.Appendf(b, "%s: %s\r\n", name, value)
+		}
+	}
+	return b
+}
+
+// Status returns the status code sent with WriteStatusLine, or 0 if it hasn't been written yet
+func (w *Writer) Status() StatusCode {
+	return w.statusCode
+}
+
+// BodyBytes returns how many body bytes were written so far, counting the
+// data of chunks but not the chunk framing
+func (w *Writer) BodyBytes() int64 {
+	return w.bodyWritten
+}
+
+// Headers returns the headers sent with WriteHeaders, or nil if they haven't been written yet
+func (w *Writer) Headers() headers.Headers {
+	return w.headers
+}
+
+// WriteBody writes body bytes after the headers
+// Returns: (bytes written, error)
+// With SetContentLength, writing past the declared length fails with
+// ERROR_BODY_EXCEEDS_CONTENT_LENGTH and nothing is written.
+// With UseChunked it fails with ERROR_WRONG_BODY_FRAMING; use WriteChunkedBody.
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if err := w.checkBody(); err != nil {
+		return 0, err
+	}
+
+	switch w.framing {
+	case framingChunked:
+		return 0, ERROR_WRONG_BODY_FRAMING
+	case framingLength:
+		if w.bodyWritten+int64(len(p)) > w.contentLength {
+			return 0, ERROR_BODY_EXCEEDS_CONTENT_LENGTH
+		}
+	}
+
+	n, err := w.writer.Write(p)
+	w.bodyWritten += int64(n)
+	return n, err
+}
+
+// checkBody returns an error unless the writer is ready for body bytes
+func (w *Writer) checkBody() error {
+	switch w.state {
+	case StateBody:
+		return nil
+	case StateDone:
+		return ERROR_BODY_ALREADY_DONE
+	case StateHijacked:
+		return ERROR_HIJACKED
+	}
+	return ERROR_BODY_BEFORE_HEADERS
+}
+
+// Done reports whether the body was explicitly finished with WriteChunkedBodyDone or WriteTrailers
+func (w *Writer) Done() bool {
+	return w.state == StateDone
+}
+
+// BodyComplete reports whether the body the headers declared was written in
+// full: exactly Content-Length bytes, or a chunked body finished with
+// WriteChunkedBodyDone or WriteTrailers. A body with neither runs until the
+// connection closes, so it's never complete.
+func (w *Writer) BodyComplete() bool {
+	switch {
+	case w.state == StateDone:
+		return true
+	case w.state != StateBody || w.chunkedBody:
+		return false
+	}
+
+	n, err := strconv.ParseInt(w.headers.Get("Content-Length"), 10, 64)
+	return err == nil && w.bodyWritten == n
+}
@@ -0,0 +1,277 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	// Test: Full response in order
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{
+		"Content-Type":   {"text/plain"},
+		"Content-Length": {"5"},
+	}))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello", buf.String())
+	assert.Equal(t, StatusOK, w.Status())
+	assert.Equal(t, int64(5), w.BodyBytes())
+
+	// Test: Each value of a repeated field gets its own line
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := headers.NewHeaders()
+	h.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+	h.Add("Set-Cookie", "b=2")
+	h.Set("Content-Length", "0")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\n", buf.String())
+
+	// Test: Reason phrases
+	buf = &bytes.Buffer{}
+	require.NoError(t, NewWriter(buf).WriteStatusLine(StatusBadRequest))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", buf.String())
+
+	buf = &bytes.Buffer{}
+	require.NoError(t, NewWriter(buf).WriteStatusLine(StatusServiceUnavailable))
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable\r\n", buf.String())
+	assert.Equal(t, "Not Found", StatusNotFound.ReasonPhrase())
+	assert.Equal(t, "Content Too Large", StatusContentTooLarge.ReasonPhrase())
+
+	buf = &bytes.Buffer{}
+	require.NoError(t, NewWriter(buf).WriteStatusLine(StatusCode(299)))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: Out of order writes
+	w = NewWriter(&bytes.Buffer{})
+	assert.Equal(t, StatusCode(0), w.Status())
+	assert.Equal(t, ERROR_HEADERS_BEFORE_STATUS_LINE, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("x"))
+	assert.Equal(t, ERROR_BODY_BEFORE_HEADERS, err)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, ERROR_STATUS_LINE_ALREADY_WRITTEN, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, ERROR_HEADERS_ALREADY_WRITTEN, w.WriteHeaders(headers.NewHeaders()))
+}
+
+func TestChunkedBody(t *testing.T) {
+	// Test: Chunked body with several chunks
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Transfer-Encoding": {"chunked"}}))
+	n, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, err = w.WriteChunkedBody([]byte(strings.Repeat("x", 26)))
+	require.NoError(t, err)
+	n, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, w.Done())
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.True(t, w.Done())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n"+
+		"1a\r\n"+strings.Repeat("x", 26)+"\r\n"+
+		"0\r\n\r\n", buf.String())
+	assert.Equal(t, int64(31), w.BodyBytes())
+
+	// Test: Nothing can be written after the body is done
+	_, err = w.WriteChunkedBody([]byte("late"))
+	assert.Equal(t, ERROR_BODY_ALREADY_DONE, err)
+	_, err = w.WriteBody([]byte("late"))
+	assert.Equal(t, ERROR_BODY_ALREADY_DONE, err)
+
+	// Test: Chunks can't be written before the headers
+	_, err = NewWriter(&bytes.Buffer{}).WriteChunkedBody([]byte("early"))
+	assert.Equal(t, ERROR_BODY_BEFORE_HEADERS, err)
+}
+
+func TestTrailers(t *testing.T) {
+	// Test: Declared trailers are sent after the last chunk
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{
+		"Transfer-Encoding": {"chunked"},
+		"Trailer":           {"X-Content-SHA256, x-content-length"},
+	}))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{
+		"X-Content-Sha256": {"2cf24dba"},
+		"X-Content-Length": {"5"},
+	}))
+	assert.True(t, w.Done())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Trailer: X-Content-SHA256, x-content-length\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n"+
+		"0\r\n"+
+		"X-Content-Length: 5\r\n"+
+		"X-Content-Sha256: 2cf24dba\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Nothing can be written after the trailers
+	assert.Equal(t, ERROR_BODY_ALREADY_DONE, w.WriteTrailers(nil))
+
+	// Test: Undeclared trailer is rejected and the body left open
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Transfer-Encoding": {"chunked"}, "Trailer": {"X-Checksum"}}))
+	assert.ErrorIs(t, w.WriteTrailers(headers.Headers{"X-Other": {"1"}}), ERROR_UNDECLARED_TRAILER)
+	assert.False(t, w.Done())
+}
+
+func TestGetDefaultHeaders(t *testing.T) {
+	// Test: Defaults for a 15 byte body
+	h := GetDefaultHeaders(15)
+	assert.Equal(t, "15", h.Get("Content-Length"))
+	assert.Equal(t, "close", h.Get("Connection"))
+	assert.Equal(t, "text/plain", h.Get("Content-Type"))
+	date, err := time.Parse(TimeFormat, h.Get("Date"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, 5*time.Second)
+
+	// Test: Fields can be overridden before writing
+	h.Set("Content-Type", "text/html")
+	h.Delete("Connection")
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Contains(t, buf.String(), "Content-Type: text/html\r\n")
+	assert.NotContains(t, buf.String(), "Connection:")
+}
+
+func TestContentRange(t *testing.T) {
+	// Test: Valid ranges, including a single byte and the whole resource
+	cr, err := ContentRange(0, 499, 1234)
+	require.NoError(t, err)
+	assert.Equal(t, "bytes 0-499/1234", cr)
+	cr, err = ContentRange(1233, 1233, 1234)
+	require.NoError(t, err)
+	assert.Equal(t, "bytes 1233-1233/1234", cr)
+	cr, err = ContentRange(0, 1233, 1234)
+	require.NoError(t, err)
+	assert.Equal(t, "bytes 0-1233/1234", cr)
+
+	// Test: Ranges outside the resource
+	for _, r := range [][3]int64{{-1, 10, 100}, {10, 9, 100}, {0, 100, 100}, {0, 0, 0}} {
+		_, err = ContentRange(r[0], r[1], r[2])
+		assert.ErrorIs(t, err, ERROR_INVALID_RANGE, r)
+	}
+
+	// Test: 416 form
+	assert.Equal(t, "bytes */1234", UnsatisfiableContentRange(1234))
+}
+
+func TestFraming(t *testing.T) {
+	// Test: SetContentLength overrides the headers and bounds the body
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.SetContentLength(5))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := headers.Headers{"Transfer-Encoding": {"chunked"}, "Content-Length": {"99"}}
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "chunked", h.Get("Transfer-Encoding"), "caller's headers are left alone")
+	_, err := w.WriteBody([]byte("hel"))
+	require.NoError(t, err)
+	assert.False(t, w.BodyComplete())
+	_, err = w.WriteBody([]byte("lo!"))
+	assert.Equal(t, ERROR_BODY_EXCEEDS_CONTENT_LENGTH, err)
+	_, err = w.WriteBody([]byte("lo"))
+	require.NoError(t, err)
+	assert.True(t, w.BodyComplete())
+	_, err = w.WriteChunkedBody([]byte("x"))
+	assert.Equal(t, ERROR_WRONG_BODY_FRAMING, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", buf.String())
+
+	// Test: UseChunked overrides the headers and needs chunked writes
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.UseChunked())
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Content-Length": {"5"}}))
+	_, err = w.WriteBody([]byte("hello"))
+	assert.Equal(t, ERROR_WRONG_BODY_FRAMING, err)
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", buf.String())
+
+	// Test: The two can't be mixed, or changed once the headers are out
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.SetContentLength(5))
+	require.NoError(t, w.SetContentLength(6))
+	assert.Equal(t, ERROR_FRAMING_ALREADY_SET, w.UseChunked())
+	assert.Equal(t, ERROR_INVALID_CONTENT_LENGTH, NewWriter(&bytes.Buffer{}).SetContentLength(-1))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, ERROR_HEADERS_ALREADY_WRITTEN, w.SetContentLength(7))
+	assert.Equal(t, ERROR_HEADERS_ALREADY_WRITTEN, w.UseChunked())
+
+	// Test: Chunks need a chunked body announced, not just an unset framing
+	for _, h := range []headers.Headers{{"Content-Length": {"5"}}, {}} {
+		buf = &bytes.Buffer{}
+		w = NewWriter(buf)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		_, err = w.WriteChunkedBody([]byte("hello"))
+		assert.Equal(t, ERROR_WRONG_BODY_FRAMING, err)
+		_, err = w.WriteChunkedBodyDone()
+		assert.Equal(t, ERROR_WRONG_BODY_FRAMING, err)
+		assert.Equal(t, ERROR_WRONG_BODY_FRAMING, w.WriteTrailers(nil))
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"), buf.String())
+	}
+}
+
+func TestDowngradeToHTTP10(t *testing.T) {
+	// Test: Chunked responses are sent raw and delimited by closing the connection
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.DowngradeToHTTP10()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Transfer-Encoding": {"chunked"}, "Trailer": {"X-Checksum"}}))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"X-Checksum": {"abc"}}))
+	assert.True(t, w.Done())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\nTrailer: X-Checksum\r\n\r\nhello world", buf.String())
+	assert.Equal(t, "close", w.Headers().Get("Connection"))
+
+	// Test: Fixed-length responses are untouched
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.DowngradeToHTTP10()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"Content-Length": {"2"}}))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n", buf.String())
+}
+
+func TestHijack(t *testing.T) {
+	// Test: Writers that don't write to a connection can't be hijacked
+	w := NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	assert.Equal(t, ERROR_NOT_HIJACKABLE, err)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+}
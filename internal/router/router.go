@@ -0,0 +1,281 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+	"github.com/jrooke/httpfromtcp/internal/server"
+	"github.com/jrooke/httpfromtcp/internal/urlenc"
+)
+
+// Router dispatches requests to handlers by method and path, so an
+// application doesn't have to switch on the target in one big handler.
+// Router.Serve is a server.Handler:
+//
+//	r := router.New()
+//	r.Handle("GET", "/users/{id}", getUser)
+//	r.Handle("GET", "/static/{file...}", serveStatic)
+//	s, err := server.Serve(8080, r.Serve)
+//
+// Patterns are matched segment by segment against the decoded path:
+//   - a literal segment ("users") must match exactly
+//   - "{name}" matches any one non-empty segment
+//   - "{name...}", only as the last segment, matches the rest of the path
+//
+// Matched parameters are available through req.PathValue. When several
+// patterns match, the more specific one wins: at the first segment where
+// they differ a literal beats "{name}", which beats "{name...}".
+// A path no pattern matches gets NotFound; a path that only matches
+// patterns for other methods gets 405 Method Not Allowed with an Allow header.
+type Router struct {
+	// NotFound handles requests no pattern matches, a plain 404 when nil
+	NotFound server.Handler
+
+	routes []route
+}
+
+// route is one registered method and pattern
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+// segment is one "/"-separated piece of a pattern
+type segment struct {
+	// literal is the text to match, when name is empty
+	literal string
+
+	// name is the parameter a "{name}" or "{name...}" segment captures
+	name string
+
+	// rest is set for "{name...}", which captures the remaining path
+	rest bool
+}
+
+// New creates a Router with no routes
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for requests with the given method whose path matches pattern.
+// Panics on a malformed pattern or one already registered for the method,
+// since both are programming errors found at startup.
+// Example: r.Handle("GET", "/users/{id}", h)
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	for _, r := range rt.routes {
+		if r.method == method && samePattern(r.segments, segments) {
+			panic(fmt.Sprintf("router: %s %s conflicts with %s %s", method, pattern, r.method, r.pattern))
+		}
+	}
+
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+// parsePattern splits a pattern into segments
+// Example: "/users/{id}/posts" → [users] [{id}] [posts]
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("router: pattern %q must start with /", pattern)
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	names := map[string]bool{}
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("router: pattern %q has a malformed parameter %q", pattern, part)
+			}
+			segments = append(segments, segment{literal: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		rest := false
+		if n, ok := strings.CutSuffix(name, "..."); ok {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("router: pattern %q has %q before the last segment", pattern, part)
+			}
+			name, rest = n, true
+		}
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("router: pattern %q has a malformed parameter %q", pattern, part)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("router: pattern %q repeats parameter %q", pattern, name)
+		}
+		names[name] = true
+		segments = append(segments, segment{name: name, rest: rest})
+	}
+	return segments, nil
+}
+
+// samePattern reports whether two patterns match exactly the same paths,
+// i.e. only their parameter names differ
+func samePattern(a, b []segment) bool {
+	return slices.EqualFunc(a, b, func(x, y segment) bool {
+		return x.literal == y.literal && (x.name == "") == (y.name == "") && x.rest == y.rest
+	})
+}
+
+// Serve dispatches req to the handler of the best matching route, see Router
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	u, err := req.URL()
+	if err != nil {
+		writeError(w, response.StatusBadRequest, nil)
+		return
+	}
+	path, ok := splitPath(u.RawPath)
+	if !ok {
+		rt.notFound(w, req)
+		return
+	}
+
+	var best *route
+	var bestParams map[string]string
+	allowed := []string{}
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		params, ok := match(r.segments, path)
+		if !ok {
+			continue
+		}
+		if r.method != req.RequestLine.Method {
+			allowed = append(allowed, r.method)
+			continue
+		}
+		if best == nil || moreSpecific(r.segments, best.segments) {
+			best, bestParams = r, params
+		}
+	}
+
+	switch {
+	case best != nil:
+		req.PathParams = bestParams
+		best.handler(w, req)
+	case len(allowed) > 0:
+		slices.Sort(allowed)
+		writeError(w, response.StatusMethodNotAllowed, map[string]string{"Allow": strings.Join(slices.Compact(allowed), ", ")})
+	default:
+		rt.notFound(w, req)
+	}
+}
+
+// notFound answers a request no route matches
+func (rt *Router) notFound(w *response.Writer, req *request.Request) {
+	if rt.NotFound != nil {
+		rt.NotFound(w, req)
+		return
+	}
+	writeError(w, response.StatusNotFound, nil)
+}
+
+// splitPath splits an origin-form raw path into decoded segments.
+// Returns false for targets without a path (authority-form, "*").
+// Each segment is decoded on its own so "%2F" doesn't create a new segment.
+// Example: "/users/a%2Fb" → ["users", "a/b"]
+func splitPath(rawPath string) ([]string, bool) {
+	if !strings.HasPrefix(rawPath, "/") {
+		return nil, false
+	}
+
+	parts := strings.Split(rawPath[1:], "/")
+	for i, part := range parts {
+		decoded, err := urlenc.PathUnescape(part)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = decoded
+	}
+	return parts, true
+}
+
+// match checks path against a pattern's segments and returns the captured parameters
+func match(segments []segment, path []string) (map[string]string, bool) {
+	var params map[string]string
+	capture := func(name, value string) {
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[name] = value
+	}
+
+	for i, seg := range segments {
+		if i >= len(path) {
+			return nil, false
+		}
+		if seg.rest {
+			capture(seg.name, strings.Join(path[i:], "/"))
+			return params, true
+		}
+		switch {
+		case seg.name == "":
+			if path[i] != seg.literal {
+				return nil, false
+			}
+		case path[i] == "":
+			return nil, false
+		default:
+			capture(seg.name, path[i])
+		}
+	}
+	return params, len(path) == len(segments)
+}
+
+// moreSpecific reports whether pattern a should win over pattern b when both match
+func moreSpecific(a, b []segment) bool {
+	rank := func(s segment) int {
+		switch {
+		case s.name == "":
+			return 2
+		case !s.rest:
+			return 1
+		}
+		return 0
+	}
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if ra, rb := rank(a[i]), rank(b[i]); ra != rb {
+			return ra > rb
+		}
+	}
+	return len(a) > len(b)
+}
+
+// writeError writes a plain text response for status with any extra headers
+func writeError(w *response.Writer, status response.StatusCode, extra map[string]string) {
+	body := status.ReasonPhrase() + "\n"
+	h := response.GetDefaultHeaders(len(body))
+	// Let the connection carry on, a wrong path isn't a reason to close it
+	h.Delete("Connection")
+	for name, value := range extra {
+		h.Set(name, value)
+	}
+
+	if err := w.WriteStatusLine(status); err != nil {
+		log.Printf("router: error writing status line: %v", err)
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		log.Printf("router: error writing headers: %v", err)
+		return
+	}
+	if _, err := w.WriteBody([]byte(body)); err != nil {
+		log.Printf("router: error writing body: %v", err)
+	}
+}
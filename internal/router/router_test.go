@@ -0,0 +1,92 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+	"github.com/jrooke/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve routes one raw request through rt and returns the raw response
+func serve(t *testing.T, rt *Router, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	rt.Serve(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+// echo answers with name and the request's path parameters
+func echo(name string, params ...string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		body := name
+		for _, p := range params {
+			body += " " + p + "=" + req.PathValue(p)
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/", echo("index"))
+	rt.Handle("GET", "/users/{id}", echo("user", "id"))
+	rt.Handle("DELETE", "/users/{id}", echo("delete", "id"))
+	rt.Handle("GET", "/users/me", echo("me"))
+	rt.Handle("GET", "/users/{id}/posts/{post}", echo("post", "id", "post"))
+	rt.Handle("GET", "/static/{file...}", echo("static", "file"))
+
+	// Test: Literal and parameter matches
+	for _, tc := range []struct {
+		target string
+		body   string
+	}{
+		{"/", "index"},
+		{"/users/42", "user id=42"},
+		{"/users/me", "me"},
+		{"/users/42/posts/7?x=1", "post id=42 post=7"},
+		{"/users/a%2Fb", "user id=a/b"},
+		{"/static/css/site.css", "static file=css/site.css"},
+		{"http://example.com/users/42", "user id=42"},
+	} {
+		resp := serve(t, rt, "GET "+tc.target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"+tc.body), tc.target+": "+resp)
+	}
+	assert.Contains(t, serve(t, rt, "DELETE /users/42 HTTP/1.1\r\nHost: localhost\r\n\r\n"), "delete id=42")
+
+	// Test: Nothing matches
+	for _, target := range []string{"/users", "/users/", "/users/42/posts", "/static", "/nope"} {
+		resp := serve(t, rt, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.Contains(t, resp, "HTTP/1.1 404 Not Found\r\n", target)
+		assert.NotContains(t, resp, "Connection: close", target)
+	}
+
+	// Test: The path exists for other methods only
+	resp := serve(t, rt, "POST /users/42 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 405 Method Not Allowed\r\n")
+	assert.Contains(t, resp, "Allow: DELETE, GET\r\n")
+
+	// Test: Custom NotFound
+	rt.NotFound = echo("custom")
+	assert.True(t, strings.HasSuffix(serve(t, rt, "GET /nope HTTP/1.1\r\nHost: localhost\r\n\r\n"), "custom"))
+}
+
+func TestHandlePanics(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/users/{id}", echo("user"))
+
+	// Test: Malformed patterns and conflicting registrations
+	for _, pattern := range []string{"users", "/{}", "/{id", "/a{id}", "/{rest...}/x", "/{id}/{id}", "/users/{name}"} {
+		assert.Panics(t, func() { rt.Handle("GET", pattern, echo("x")) }, pattern)
+	}
+
+	// Test: The same pattern for another method is fine
+	assert.NotPanics(t, func() { rt.Handle("PUT", "/users/{id}", echo("x")) })
+}
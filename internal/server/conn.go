@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+)
+
+// SlowConsumerError is returned from response writes when the client
+// stopped reading and a single write didn't finish within Options.WriteTimeout
+type SlowConsumerError struct {
+	RemoteAddr net.Addr
+	Timeout    time.Duration
+
+	// Err is the underlying timeout from the connection
+	Err error
+}
+
+func (e *SlowConsumerError) Error() string {
+	return fmt.Sprintf("server: slow consumer %s: write blocked for more than %s", e.RemoteAddr, e.Timeout)
+}
+
+func (e *SlowConsumerError) Unwrap() error {
+	return e.Err
+}
+
+// connWriter is what handlers write the response through.
+// It remembers whether anything was written (so a panic can still become a 500),
+// renews the write deadline before every write and records the first failure
+// so the connection isn't reused after a broken response.
+type connWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+	written bool
+	err     error
+
+	// reader holds the bytes already read past the request, for Hijack
+	reader *request.Reader
+
+	// hijacked is set once the handler took the connection over
+	hijacked bool
+}
+
+// Hijack implements response.Hijacker: it clears the connection's deadlines
+// and hands it over together with the bytes the request reader had buffered
+func (cw *connWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cw.err != nil {
+		return nil, nil, cw.err
+	}
+	cw.hijacked = true
+	cw.err = response.ERROR_HIJACKED
+	cw.conn.SetDeadline(time.Time{})
+
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(cw.reader.Buffered()), cw.conn))
+	return cw.conn, bufio.NewReadWriter(r, bufio.NewWriter(cw.conn)), nil
+}
+
+func (cw *connWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	if len(p) > 0 {
+		cw.written = true
+	}
+
+	// Rolling deadline: each write gets the full timeout, so a long
+	// streaming response is fine as long as the client keeps reading
+	if cw.timeout > 0 {
+		cw.conn.SetWriteDeadline(time.Now().Add(cw.timeout))
+		defer cw.conn.SetWriteDeadline(time.Time{})
+	}
+
+	n, err := cw.conn.Write(p)
+	if err != nil {
+		if isTimeout(err) {
+			err = &SlowConsumerError{RemoteAddr: cw.conn.RemoteAddr(), Timeout: cw.timeout, Err: err}
+		}
+		cw.err = err
+	}
+	return n, err
+}
+
+// isTimeout reports whether err is a deadline expiring on the connection
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
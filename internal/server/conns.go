@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// minReapInterval keeps the reaper from spinning when IdleTimeout is tiny
+const minReapInterval = 10 * time.Millisecond
+
+// connState is what the server knows about one open connection
+type connState struct {
+	// active is true while a request is being read or handled
+	active bool
+
+	// idleSince is when the connection last went idle (accepted or finished a request)
+	idleSince time.Time
+}
+
+// Stats is a snapshot of the server's connection accounting, see Server.Stats
+type Stats struct {
+	// OpenConnections is the number of connections currently open
+	OpenConnections int
+
+	// IdleConnections is how many of those are waiting for their next request
+	IdleConnections int
+
+	// ReapedConnections counts connections the idle reaper has closed since the server started
+	ReapedConnections uint64
+}
+
+// Stats returns the current connection counts
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		OpenConnections:   len(s.conns),
+		ReapedConnections: s.reaped.Load(),
+	}
+	for _, state := range s.conns {
+		if !state.active {
+			stats.IdleConnections++
+		}
+	}
+	return stats
+}
+
+// trackConn registers a just-accepted connection as idle (no request read yet).
+// It's called before the connection gets a goroutine, so Shutdown can't miss it.
+// Returns false, without tracking it, once Shutdown has started.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[net.Conn]*connState{}
+	}
+	s.conns[conn] = &connState{idleSince: time.Now()}
+	return true
+}
+
+// untrackConn forgets a connection once handle (or reject) is done with it
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+// setActive marks conn as handling a request (true) or waiting for the next one (false).
+// Returns false if the connection was already closed (by Shutdown or the reaper),
+// or Shutdown is in progress and the connection just went idle, either way handle should stop.
+func (s *Server) setActive(conn net.Conn, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.conns[conn]
+	if !ok {
+		return false
+	}
+	if !active && s.shuttingDown.Load() {
+		return false
+	}
+	if state.active && !active {
+		state.idleSince = time.Now()
+	}
+	state.active = active
+	return true
+}
+
+// closeIdleConns closes (and forgets) every connection that isn't handling a request.
+// Returns true when no connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if !state.active {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// closeAllConns closes every connection, whether or not it's handling a request
+func (s *Server) closeAllConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// reap periodically closes connections that have been idle longer than IdleTimeout.
+// The read deadline normally does this, but it only fires while a goroutine is
+// blocked reading; the reaper also catches connections whose deadline got lost
+// or extended (e.g. a wrapped net.Conn that ignores SetReadDeadline).
+// Runs until done is closed.
+func (s *Server) reap(done <-chan struct{}) {
+	interval := max(s.options.IdleTimeout/2, minReapInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.reapIdleConns(now)
+		}
+	}
+}
+
+// reapIdleConns closes the connections idle since before now-IdleTimeout
+func (s *Server) reapIdleConns(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if !state.active && now.Sub(state.idleSince) > s.options.IdleTimeout {
+			conn.Close()
+			delete(s.conns, conn)
+			s.reaped.Add(1)
+		}
+	}
+}
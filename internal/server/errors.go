@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+)
+
+// ParseErrorResponse is how the server answers one kind of parse error
+type ParseErrorResponse struct {
+	// Err is the parser's sentinel error, matched with errors.Is
+	Err error
+
+	// Status is the status code answered
+	Status response.StatusCode
+
+	// Reason is a short, stable description of the error kind, for logs and
+	// metrics. The response body carries the full error text instead, except
+	// for a 500, whose error is about the server and gets a generic body.
+	Reason string
+
+	// Close is whether the connection has to be closed after the response,
+	// which the response then announces with "Connection: close". Without it
+	// the server keeps reading requests, but only when the parser says the
+	// stream is still at a request boundary (request.Reader.Resumable);
+	// otherwise the connection is closed regardless.
+	Close bool
+}
+
+// ParseErrorResponses maps every error the request parser returns to its
+// response, in the order they're matched. The server answers parse errors
+// through it and embedders reading requests themselves can use it too, see
+// ParseErrorResponseFor. New parser errors belong here.
+// Changing it changes how every server in the process answers, so do it
+// before starting any.
+var ParseErrorResponses = []ParseErrorResponse{
+	// Request line
+	{request.ERROR_REQUEST_LINE_TOO_LONG, response.StatusURITooLong, "request line too long", true},
+	{request.ERROR_TARGET_TOO_LONG, response.StatusURITooLong, "request target too long", true},
+	{request.ERROR_UNSUPPORTED_METHOD, response.StatusNotImplemented, "unsupported method", false},
+	{request.ERROR_UNSUPPORTED_HTTP_VERSION, response.StatusHTTPVersionNotSupported, "unsupported HTTP version", true},
+	{request.ERROR_MALFORMED_METHOD, response.StatusBadRequest, "malformed method", true},
+	{request.ERROR_MALFORMED_TARGET, response.StatusBadRequest, "malformed request target", true},
+	{request.ERROR_MALFORMED_REQUEST_LINE, response.StatusBadRequest, "malformed request line", true},
+
+	// Header section
+	{request.ERROR_HEADERS_TOO_LARGE, response.StatusRequestHeaderFieldsTooLarge, "header section too large", true},
+	{request.ERROR_TOO_MANY_HEADERS, response.StatusRequestHeaderFieldsTooLarge, "too many header fields", true},
+	{request.ERROR_BUFFER_FULL, response.StatusRequestHeaderFieldsTooLarge, "read buffer full", true},
+	{headers.ERROR_BARE_CR, response.StatusBadRequest, "bare CR", true},
+	{headers.ERROR_INVALID_FIELD_NAME, response.StatusBadRequest, "invalid field name", true},
+	{headers.ERROR_INVALID_FIELD_VALUE, response.StatusBadRequest, "invalid field value", true},
+	{headers.ERROR_OBS_FOLD, response.StatusBadRequest, "obsolete line folding", true},
+	{request.ERROR_MISSING_HOST, response.StatusBadRequest, "missing Host", false},
+	{request.ERROR_DUPLICATE_HOST, response.StatusBadRequest, "duplicate Host", true},
+	{request.ERROR_INVALID_HOST, response.StatusBadRequest, "invalid Host", true},
+
+	// Body framing
+	{request.ERROR_MALFORMED_CONTENT_LENGTH, response.StatusBadRequest, "malformed Content-Length", true},
+	{request.ERROR_CONFLICTING_BODY_LENGTH, response.StatusBadRequest, "both Content-Length and Transfer-Encoding", true},
+	{request.ERROR_UNSUPPORTED_TRANSFER_ENCODING, response.StatusNotImplemented, "unsupported Transfer-Encoding", true},
+	{request.ERROR_CHUNK_SIZE_LINE_TOO_LONG, response.StatusBadRequest, "chunk size line too long", true},
+	{request.ERROR_MALFORMED_CHUNK, response.StatusBadRequest, "malformed chunk", true},
+	{request.ERROR_UNANNOUNCED_TRAILER, response.StatusBadRequest, "unannounced trailer", true},
+	{request.ERROR_FORBIDDEN_TRAILER, response.StatusBadRequest, "forbidden trailer", true},
+
+	// Server side
+	{request.ERROR_BODY_SINK, response.StatusInternalServerError, "body sink failed", true},
+}
+
+// DefaultParseErrorResponse answers parse errors missing from ParseErrorResponses
+var DefaultParseErrorResponse = ParseErrorResponse{
+	Status: response.StatusBadRequest,
+	Reason: "bad request",
+	Close:  true,
+}
+
+// ParseErrorResponseFor returns the first entry of ParseErrorResponses that
+// err matches, or DefaultParseErrorResponse (with Err set to err)
+// Example: a wrapped request.ERROR_TARGET_TOO_LONG → 414, "request target too long"
+func ParseErrorResponseFor(err error) ParseErrorResponse {
+	for _, r := range ParseErrorResponses {
+		if errors.Is(err, r.Err) {
+			return r
+		}
+	}
+
+	r := DefaultParseErrorResponse
+	r.Err = err
+	return r
+}
@@ -0,0 +1,677 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/clock"
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+)
+
+// Handler is called once per parsed request and is responsible for the whole
+// response: status line, headers and body, written in that order through w.
+// A handler that panics is answered with a 500 if it hadn't written anything yet.
+type Handler func(w *response.Writer, req *request.Request)
+
+// Options tune how the server treats connections
+// The zero value is valid: no idle timeout, request.DefaultLimits and
+// the default request.ParserOptions
+type Options struct {
+	// IdleTimeout closes a keep-alive connection when the next request
+	// hasn't arrived within this long. 0 means wait forever.
+	// Besides the read deadline, a background reaper closes connections
+	// idle past it, see Server.Stats.
+	IdleTimeout time.Duration
+
+	// ReadHeaderTimeout bounds how long the request line and headers may take
+	// to arrive, counted from the first byte of the request, or for the first
+	// request on a connection from when it was accepted. It stops a client
+	// trickling in headers a byte at a time (slowloris), or connecting and
+	// sending nothing, from holding a goroutine and file descriptor forever.
+	// 0 means no limit.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout bounds reading the whole request, body included, counted
+	// like ReadHeaderTimeout. With StreamRequestBody the handler's body reads
+	// fail once it passes. 0 means no limit.
+	ReadTimeout time.Duration
+
+	// Limits bound the request line and headers of every request
+	// Left empty, request.DefaultLimits are used
+	Limits request.Limits
+
+	// Parser picks how strictly requests are parsed; anything rejected is answered with a 400
+	Parser request.ParserOptions
+
+	// WriteTimeout is a rolling deadline applied to every write of the response,
+	// so a client that stops reading can't pin the handler forever. A write that
+	// times out fails with a *SlowConsumerError and the connection is closed.
+	// 0 means writes may block forever.
+	WriteTimeout time.Duration
+
+	// StreamRequestBody hands handlers the body as a stream (req.BodyReader)
+	// instead of buffering it into req.Body first. Whatever the handler leaves
+	// unread is discarded before the next request on the connection.
+	StreamRequestBody bool
+
+	// BodySinks stores request bodies in memory or temp files by the given
+	// policy so large uploads don't sit on the heap, see request.BodySinkPolicy.
+	// Handlers read them through req.BodyFile; the server releases them once
+	// the handler returns. Ignored with StreamRequestBody.
+	BodySinks *request.BodySinkPolicy
+
+	// MaxDiscardBytes is how much of a streamed body the handler didn't read
+	// the server will drain to keep the connection alive. Past that the
+	// connection is closed instead. Left at 0, DefaultMaxDiscardBytes is used.
+	MaxDiscardBytes int64
+
+	// MaxConcurrentConnections caps how many connections are handled at once,
+	// across every listener. Once it's reached the server stops accepting and
+	// further connections wait in the OS listen backlog. 0 means no limit.
+	MaxConcurrentConnections int
+
+	// MaxAcceptQueue, with MaxConcurrentConnections set, keeps accepting past
+	// the limit: up to this many connections are held waiting for a free slot
+	// and any more are answered with 503 Service Unavailable and closed, so a
+	// burst fails fast instead of leaving clients to time out in the backlog.
+	// 0 leaves the excess in the backlog.
+	MaxAcceptQueue int
+
+	// DrainingRetryAfter is sent as Retry-After with the 503 answered while
+	// the server is draining (see SetDraining), rounded up to whole seconds.
+	// Left at 0, DefaultDrainingRetryAfter is used.
+	DrainingRetryAfter time.Duration
+
+	// OnPanic is called with the request, the recovered value and the stack
+	// trace of the panicking goroutine when a handler panics, after the 500
+	// (if any) is sent. Left nil, the panic and stack are logged with the log package.
+	OnPanic func(req *request.Request, value any, stack []byte)
+
+	// Clock stamps the Date of the server's own responses (errors, 503s while
+	// draining) and times request parsing for tests. Left nil, clock.System is used.
+	// Read and write deadlines are enforced by the network stack against the
+	// wall clock, so IdleTimeout and WriteTimeout always run in real time.
+	Clock clock.Clock
+}
+
+// DefaultDrainingRetryAfter is the default for Options.DrainingRetryAfter
+const DefaultDrainingRetryAfter = 5 * time.Second
+
+// DefaultMaxDiscardBytes is the default for Options.MaxDiscardBytes
+const DefaultMaxDiscardBytes = 256 * 1024
+
+// ERROR_SERVER_CLOSED is returned when a listener is added to a server that was already closed
+var ERROR_SERVER_CLOSED = fmt.Errorf("ERROR: Server closed")
+
+// Server accepts connections, parses requests from them
+// and lets its Handler write the responses. Connections are kept
+// alive between requests (HTTP/1.1 persistent connections) until
+// either side asks to close.
+// One Server can accept on several listeners (e.g. :80, :8080 and a unix
+// socket), all sharing the same handler, options and Close.
+type Server struct {
+	handler Handler
+	options Options
+	closed  atomic.Bool
+
+	// draining makes the server turn new requests away, see SetDraining
+	draining atomic.Bool
+
+	// shuttingDown is set by Shutdown, connections close after their current request
+	shuttingDown atomic.Bool
+
+	// reaped counts connections closed by the idle reaper, see Stats
+	reaped atomic.Uint64
+
+	// slots holds one token per connection being handled and queue one per
+	// accepted connection waiting for a slot, nil when there's no limit
+	slots chan struct{}
+	queue chan struct{}
+
+	// done is closed by Close, stopping the idle reaper and queued connections
+	done chan struct{}
+
+	// mu guards listeners, which are added by Listen/ServeListener and closed by Close,
+	// conns, the accounting for every open connection, and reaping, set once
+	// the idle reaper is started
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]*connState
+	reaping   bool
+}
+
+// Serve starts listening on the given port and returns immediately.
+// Connections are accepted in a background goroutine until Close is called.
+// Port 0 picks a free port, see Addr.
+func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithOptions(port, handler, Options{})
+}
+
+// ServeWithOptions behaves like Serve but applies the given options to every connection
+func ServeWithOptions(port int, handler Handler, options Options) (*Server, error) {
+	s := NewServer(handler, options)
+	if err := s.Listen("tcp", fmt.Sprintf(":%d", port)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewServer creates a Server that isn't listening anywhere yet,
+// add addresses with Listen or ServeListener
+func NewServer(handler Handler, options Options) *Server {
+	if options.Limits == (request.Limits{}) {
+		options.Limits = request.DefaultLimits
+	}
+	if options.MaxDiscardBytes == 0 {
+		options.MaxDiscardBytes = DefaultMaxDiscardBytes
+	}
+	if options.DrainingRetryAfter == 0 {
+		options.DrainingRetryAfter = DefaultDrainingRetryAfter
+	}
+	if options.Clock == nil {
+		options.Clock = clock.System
+	}
+
+	s := &Server{
+		handler: handler,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	if options.MaxConcurrentConnections > 0 {
+		s.slots = make(chan struct{}, options.MaxConcurrentConnections)
+		if options.MaxAcceptQueue > 0 {
+			s.queue = make(chan struct{}, options.MaxAcceptQueue)
+		}
+	}
+	return s
+}
+
+// Listen binds another address and starts accepting on it in the background
+// Example: s.Listen("tcp", ":8080") or s.Listen("unix", "/run/app.sock")
+func (s *Server) Listen(network, address string) error {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(listener)
+}
+
+// ServeListener starts accepting connections from an existing listener in
+// the background, e.g. one wrapped by proxyproto. The server takes ownership
+// and closes it on Close. Returns ERROR_SERVER_CLOSED (and closes the
+// listener) if the server was already closed.
+func (s *Server) ServeListener(listener net.Listener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() {
+		listener.Close()
+		return ERROR_SERVER_CLOSED
+	}
+
+	s.listeners = append(s.listeners, listener)
+	go s.listen(listener)
+
+	// One reaper covers the connections from every listener
+	if s.options.IdleTimeout > 0 && !s.reaping {
+		s.reaping = true
+		go s.reap(s.done)
+	}
+	return nil
+}
+
+// Addr returns the address of the first listener, or nil if there is none
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of every listener, in the order they were added
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// Close stops accepting new connections on every listener.
+// Connections already being handled are left to finish, see Shutdown to wait for them.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed.Load() {
+		close(s.done)
+	}
+	s.closed.Store(true)
+
+	errs := []error{}
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetDraining switches maintenance mode on or off. While draining, every new
+// request (including the next one on a keep-alive connection) is answered
+// with 503 Service Unavailable, Retry-After and Connection: close, without
+// calling the handler. Requests already being handled finish normally.
+// Useful in a rolling deploy, so a load balancer moves traffic away before Close.
+func (s *Server) SetDraining(draining bool) {
+	s.draining.Store(draining)
+}
+
+// listen accepts connections from one listener and handles each in its own goroutine,
+// keeping to MaxConcurrentConnections and MaxAcceptQueue when they're set
+func (s *Server) listen(listener net.Listener) {
+	// delay is how long to wait after a failed Accept, 0 after a success
+	var delay time.Duration
+	for {
+		// Without an accept queue, wait for a free slot before accepting at all
+		if s.slots != nil && s.queue == nil {
+			select {
+			case s.slots <- struct{}{}:
+			case <-s.done:
+				return
+			}
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.slots != nil && s.queue == nil {
+				<-s.slots
+			}
+			// Accept fails once the listener is closed, that's our signal to stop
+			if s.closed.Load() {
+				return
+			}
+			// Errors like EMFILE repeat until something changes, so back off
+			// rather than spinning on them
+			delay = nextAcceptDelay(delay)
+			log.Printf("server: error accepting connection: %v; retrying in %v", err, delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+				return
+			}
+			continue
+		}
+		delay = 0
+
+		if !s.trackConn(conn) {
+			if s.slots != nil && s.queue == nil {
+				<-s.slots
+			}
+			conn.Close()
+			continue
+		}
+
+		switch {
+		case s.slots == nil:
+			go s.handle(conn)
+		case s.queue == nil:
+			go s.handleInSlot(conn)
+		default:
+			s.admit(conn)
+		}
+	}
+}
+
+// minAcceptDelay and maxAcceptDelay bound the wait after a failed Accept
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// nextAcceptDelay returns the wait after another failed Accept: minAcceptDelay
+// the first time, then doubling up to maxAcceptDelay
+// Example: 0 → 5ms → 10ms → 20ms → ... → 640ms → 1s → 1s
+func nextAcceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+	return min(2*delay, maxAcceptDelay)
+}
+
+// admit hands an accepted connection a free slot, or a place in the accept
+// queue to wait for one, or turns it away with a 503 when both are full
+func (s *Server) admit(conn net.Conn) {
+	select {
+	case s.slots <- struct{}{}:
+		go s.handleInSlot(conn)
+		return
+	default:
+	}
+
+	select {
+	case s.queue <- struct{}{}:
+		go func() {
+			select {
+			case s.slots <- struct{}{}:
+				<-s.queue
+				s.handleInSlot(conn)
+			case <-s.done:
+				<-s.queue
+				s.untrackConn(conn)
+				conn.Close()
+			}
+		}()
+	default:
+		go s.reject(conn)
+	}
+}
+
+// handleInSlot is handle for a connection holding a slot, releasing it afterwards
+func (s *Server) handleInSlot(conn net.Conn) {
+	defer func() { <-s.slots }()
+	s.handle(conn)
+}
+
+// rejectTimeout bounds how long a connection turned away by admit is kept around
+const rejectTimeout = time.Second
+
+// rejectDiscardBytes is how much of the request reject reads and throws away
+const rejectDiscardBytes = 64 * 1024
+
+// reject answers a connection the server has no room for with 503 and closes it,
+// without parsing the request. Closing with the request still unread would make
+// the kernel send a reset that can destroy the 503 before the client reads it,
+// so the write side is shut first and what the client sent is drained.
+func (s *Server) reject(conn net.Conn) {
+	defer conn.Close()
+	defer s.untrackConn(conn)
+
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	s.writeResponse(conn, response.StatusServiceUnavailable, []byte("Too many connections, retry later\n"))
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	io.Copy(io.Discard, io.LimitReader(conn, rejectDiscardBytes))
+}
+
+// handle parses requests from conn one after another, running the handler for each,
+// until the client or the handler asks to close, the connection sits idle too long
+// or a request can't be parsed
+func (s *Server) handle(conn net.Conn) {
+	// A hijacked connection belongs to the handler, which closes it
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+
+	// listen tracked conn before starting this goroutine
+	defer s.untrackConn(conn)
+
+	tlsState, err := s.handshake(conn)
+	if err != nil {
+		log.Printf("server: TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	reader := request.NewReader(conn)
+	reader.Limits = s.options.Limits
+	reader.Options = s.options.Parser
+	reader.Clock = s.options.Clock
+	reader.StreamBody = s.options.StreamRequestBody
+	reader.BodySinks = s.options.BodySinks
+
+	// Once a request starts arriving, its head and then the rest of it get their own deadlines.
+	// The first request's count from when the connection was accepted instead,
+	// so a client that connects and sends nothing can't hold it forever.
+	first := true
+	requestStart := time.Now()
+	reader.Hooks.OnFirstByte = func(time.Duration) {
+		if !first {
+			requestStart = time.Now()
+		}
+		conn.SetReadDeadline(s.readDeadline(requestStart, s.options.ReadHeaderTimeout))
+		s.setActive(conn, true)
+	}
+	reader.Hooks.OnHeaders = func(time.Duration) {
+		conn.SetReadDeadline(s.readDeadline(requestStart, 0))
+	}
+
+	for {
+		if first {
+			// Wait ReadHeaderTimeout (or ReadTimeout) for the first request's head,
+			// or IdleTimeout when neither is set
+			deadline := s.readDeadline(requestStart, s.options.ReadHeaderTimeout)
+			if deadline.IsZero() && s.options.IdleTimeout > 0 {
+				deadline = requestStart.Add(s.options.IdleTimeout)
+			}
+			conn.SetReadDeadline(deadline)
+		} else {
+			// Only wait IdleTimeout for the next request to arrive
+			requestStart = time.Now()
+			if s.options.IdleTimeout > 0 {
+				conn.SetReadDeadline(requestStart.Add(s.options.IdleTimeout))
+			} else {
+				conn.SetReadDeadline(time.Time{})
+			}
+		}
+
+		req, err := reader.ReadRequest()
+		if err != nil {
+			// Client closed the connection or went quiet, nothing to answer
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || isTimeout(err) {
+				return
+			}
+			// The connection only survives an error the table doesn't close on,
+			// and that left the parser at the start of the next request
+			keepOpen := reader.Resumable() && !s.shuttingDown.Load()
+			if !s.writeParseError(conn, err, keepOpen) || !s.setActive(conn, false) {
+				return
+			}
+			first = false
+			continue
+		}
+
+		req.RemoteAddr = conn.RemoteAddr()
+		req.LocalAddr = conn.LocalAddr()
+		req.TLS = tlsState
+
+		// A pipelined request may have been buffered without a new read, so mark it here too
+		if !s.setActive(conn, true) {
+			return
+		}
+
+		// The handler may take as long as it likes, only reading the body is bounded
+		conn.SetReadDeadline(s.readDeadline(requestStart, 0))
+
+		if s.draining.Load() {
+			req.Close()
+			h := response.GetDefaultHeadersWithClock(0, s.options.Clock)
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil(s.options.DrainingRetryAfter.Seconds()))))
+			body := []byte("Server is draining, retry later\n")
+			writeResponseWithHeaders(conn, response.StatusServiceUnavailable, h, body)
+			return
+		}
+
+		cw := &connWriter{conn: conn, timeout: s.options.WriteTimeout, reader: reader}
+		w := s.runHandler(cw, req)
+		if err := req.Close(); err != nil {
+			log.Printf("server: error releasing request body: %v", err)
+		}
+		if cw.hijacked {
+			hijacked = true
+			return
+		}
+		if cw.err != nil || !keepAlive(req, w) {
+			return
+		}
+
+		// Skip past any body the handler didn't read so the next request starts in the right place
+		if err := request.DiscardBody(req, s.options.MaxDiscardBytes); err != nil {
+			return
+		}
+
+		// Waiting for the next request, Shutdown may close the connection from here on
+		if !s.setActive(conn, false) {
+			return
+		}
+		first = false
+	}
+}
+
+// readDeadline returns the read deadline for a request that started at start:
+// the earlier of start+timeout and start+ReadTimeout, ignoring whichever is 0.
+// The zero time (no deadline) if both are.
+func (s *Server) readDeadline(start time.Time, timeout time.Duration) time.Time {
+	if s.options.ReadTimeout > 0 && (timeout == 0 || s.options.ReadTimeout < timeout) {
+		timeout = s.options.ReadTimeout
+	}
+	if timeout == 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+// keepAlive decides whether the connection can carry another request.
+// Either side sending "Connection: close" ends it, and so does a response
+// without a Content-Length or a finished chunked body: the client can only
+// find the end of that body by waiting for us to close. A body shorter than
+// its Content-Length closes it too.
+// HTTP/1.0 connections close by default: both the request and the response
+// must say "Connection: keep-alive" to keep them open.
+func keepAlive(req *request.Request, w *response.Writer) bool {
+	if hasToken(req.Headers.Get("Connection"), "close") {
+		return false
+	}
+
+	h := w.Headers()
+	if h == nil {
+		return false
+	}
+	if req.IsHTTP10() && !(hasToken(req.Headers.Get("Connection"), "keep-alive") && hasToken(h.Get("Connection"), "keep-alive")) {
+		return false
+	}
+	if hasToken(h.Get("Connection"), "close") {
+		return false
+	}
+	if hasToken(h.Get("Transfer-Encoding"), "chunked") {
+		return w.Done()
+	}
+	if h.Get("Content-Length") == "" {
+		return false
+	}
+	// HEAD, 204 and 304 responses declare a length without sending a body
+	if req.RequestLine.Method == "HEAD" || w.Status() == response.StatusNoContent || w.Status() == response.StatusNotModified {
+		return true
+	}
+	// A short body would leave the client reading the next response as its rest
+	return w.BodyComplete()
+}
+
+// hasToken reports whether a comma-separated header value contains token (case-insensitive)
+// Example: hasToken("keep-alive, Upgrade", "upgrade") → true
+func hasToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// runHandler calls the handler, recovering from panics so one bad request
+// can't take the whole process down. If the handler panicked before writing
+// anything we can still send a 500 (with Connection: close); otherwise the
+// response is already half-written and the only option is to drop the
+// connection. The panic is reported to Options.OnPanic with its stack trace.
+// Returns the Writer so the caller can inspect what the handler sent.
+func (s *Server) runHandler(cw *connWriter, req *request.Request) (w *response.Writer) {
+	w = response.NewWriter(cw)
+	if req.IsHTTP10() {
+		w.DowngradeToHTTP10()
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			// Capture the stack before anything else runs, it still shows where the panic happened
+			stack := debug.Stack()
+			if !cw.written && !cw.hijacked {
+				s.writeResponse(cw, response.StatusInternalServerError, []byte("Internal Server Error\n"))
+			}
+			if s.options.OnPanic != nil {
+				s.options.OnPanic(req, rec, stack)
+			} else {
+				log.Printf("server: panic in handler for %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, rec, stack)
+			}
+			// Either way the connection can't be trusted for another request
+			w = response.NewWriter(io.Discard)
+		}
+	}()
+
+	s.handler(w, req)
+	return w
+}
+
+// writeResponse writes a complete response with a plain text body
+func (s *Server) writeResponse(conn io.Writer, statusCode response.StatusCode, body []byte) {
+	writeResponseWithHeaders(conn, statusCode, response.GetDefaultHeadersWithClock(len(body), s.options.Clock), body)
+}
+
+// writeParseError answers a request the parser rejected as ParseErrorResponses says,
+// with the error text as the body.
+// Returns whether the connection stays open: the entry doesn't ask to close it
+// and canKeepOpen, which the caller sets when the stream can be read on.
+func (s *Server) writeParseError(conn io.Writer, err error, canKeepOpen bool) bool {
+	mapping := ParseErrorResponseFor(err)
+	keepOpen := canKeepOpen && !mapping.Close
+	body := []byte(err.Error())
+	if mapping.Status == response.StatusInternalServerError {
+		// Not the client's fault, and the error may name server files
+		body = []byte(mapping.Status.ReasonPhrase())
+	}
+	h := response.GetDefaultHeadersWithClock(len(body), s.options.Clock)
+	if keepOpen {
+		h.Delete("Connection")
+	}
+	writeResponseWithHeaders(conn, mapping.Status, h, body)
+	return keepOpen
+}
+
+// writeResponseWithHeaders is writeResponse with extra headers; Content-Length is set from body
+func writeResponseWithHeaders(conn io.Writer, statusCode response.StatusCode, h headers.Headers, body []byte) {
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	w := response.NewWriter(conn)
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		log.Printf("server: error writing response: %v", err)
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		log.Printf("server: error writing response: %v", err)
+		return
+	}
+	if _, err := w.WriteBody(body); err != nil {
+		log.Printf("server: error writing response: %v", err)
+	}
+}
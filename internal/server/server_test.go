@@ -0,0 +1,1024 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jrooke/httpfromtcp/internal/clock"
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip sends raw request bytes to the server and returns the raw response
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	return roundTripAddr(t, s.Addr(), raw)
+}
+
+// roundTripAddr is roundTrip against one specific listener address
+func roundTripAddr(t *testing.T, addr net.Addr, raw string) string {
+	conn, err := net.Dial(addr.Network(), addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+func TestServe(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		status := response.StatusOK
+		body := "All good, frfr\n"
+		switch req.RequestLine.RequestTarget {
+		case "/yourproblem":
+			status = response.StatusBadRequest
+			body = "Your problem is not my problem\n"
+		case "/panic":
+			panic("handler blew up")
+		case "/panic-after-write":
+			w.WriteStatusLine(response.StatusOK)
+			panic("handler blew up")
+		}
+
+		w.WriteStatusLine(status)
+		w.WriteHeaders(headers.Headers{
+			"Connection":     {"close"},
+			"Content-Length": {strconv.Itoa(len(body))},
+			"Content-Type":   {"text/plain"},
+		})
+		w.WriteBody([]byte(body))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Handler output becomes the body
+	resp := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Connection: close\r\n"+
+		"Content-Length: 15\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"All good, frfr\n", resp)
+
+	// Test: Handler sets the status and message
+	resp = roundTrip(t, s, "GET /yourproblem HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+	assert.Contains(t, resp, "\r\n\r\nYour problem is not my problem\n")
+
+	// Test: Malformed request gets a 400
+	resp = roundTrip(t, s, "GET HTTP/1.1\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+
+	// Test: Panicking handler gets a 500
+	resp = roundTrip(t, s, "GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 500 Internal Server Error\r\n")
+
+	// Test: Panic after the status line was written just closes the connection
+	resp = roundTrip(t, s, "GET /panic-after-write HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", resp)
+
+	// Test: Close stops accepting connections
+	require.NoError(t, s.Close())
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestKeepAlive(t *testing.T) {
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		body := "you asked for " + req.RequestLine.RequestTarget
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{
+			"Content-Length": {strconv.Itoa(len(body))},
+			"Content-Type":   {"text/plain"},
+		})
+		w.WriteBody([]byte(body))
+	}, Options{IdleTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Two requests on one connection, the second asks to close
+	resp := roundTrip(t, s, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /two HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "you asked for /one")
+	assert.True(t, strings.HasSuffix(resp, "you asked for /two"))
+
+	// Test: Idle connection is closed after IdleTimeout
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /idle HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	start := time.Now()
+	all, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(all), "you asked for /idle")
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: A body shorter than its Content-Length closes the connection,
+	// so the next response can't be read as the rest of it
+	s2, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {"10"}})
+		w.WriteBody([]byte("short"))
+	})
+	require.NoError(t, err)
+	defer s2.Close()
+	resp = roundTrip(t, s2, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort", resp)
+}
+
+func TestRequestLimits(t *testing.T) {
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {"0"}, "Connection": {"close"}})
+	}, Options{Limits: request.Limits{MaxRequestLineBytes: 64, MaxTargetBytes: 16, MaxHeaderBytes: 64}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Long request line gets a 414
+	resp := roundTrip(t, s, "GET /"+strings.Repeat("a", 80)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 414 URI Too Long\r\n")
+
+	// Test: Long request target gets a 414 explaining the limit
+	resp = roundTrip(t, s, "GET /"+strings.Repeat("a", 20)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 414 URI Too Long\r\n")
+	assert.Contains(t, resp, "21 bytes, the limit is 16 bytes")
+
+	// Test: Large headers get a 431
+	resp = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: "+strings.Repeat("c", 80)+"\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 431 Request Header Fields Too Large\r\n")
+
+	// Test: Method outside the allowlist gets a 501
+	s2, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {"0"}})
+	}, Options{
+		Parser: request.ParserOptions{AllowedMethods: []string{"GET"}},
+	})
+	require.NoError(t, err)
+	defer s2.Close()
+	resp = roundTrip(t, s2, "BREW /pot HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 501 Not Implemented\r\n")
+
+	// Test: The 501 keeps the connection open, as its table entry says, and the next request is served
+	resp = roundTrip(t, s2, "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	first, second, ok := strings.Cut(resp, "HTTP/1.1 200 OK\r\n")
+	require.True(t, ok, resp)
+	assert.True(t, strings.HasPrefix(first, "HTTP/1.1 501 Not Implemented\r\n"), resp)
+	assert.NotContains(t, first, "Connection: close")
+	assert.NotEmpty(t, second)
+
+	// Test: Unless the request had a body, which is left unread
+	resp = roundTrip(t, s2, "BREW /pot HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc"+
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1 "), resp)
+	assert.Contains(t, resp, "Connection: close\r\n")
+}
+
+func TestStreamRequestBody(t *testing.T) {
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		// Only /echo reads the body, /ignore leaves it on the connection
+		body := []byte{}
+		if req.RequestLine.RequestTarget == "/echo" {
+			body, _ = io.ReadAll(req.BodyReader())
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {strconv.Itoa(len(body))}})
+		w.WriteBody(body)
+	}, Options{StreamRequestBody: true, MaxDiscardBytes: 8})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Streamed body is echoed, ignored body is skipped before the next request
+	resp := roundTrip(t, s, "POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"+
+		"POST /ignore HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nworld"+
+		"POST /echo HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n3\r\nbye\r\n0\r\n\r\n")
+	assert.Equal(t, 3, strings.Count(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "\r\n\r\nhello")
+	assert.NotContains(t, resp, "world")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nbye"))
+
+	// Test: Unread body over MaxDiscardBytes closes the connection
+	// The body is sent only after the response, so the server has to drain it from the wire
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "POST /ignore HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5000\r\n\r\n")
+	require.NoError(t, err)
+	head := make([]byte, len("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	_, err = io.ReadFull(conn, head)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", string(head))
+
+	// The server may reset the connection with body still unread, so write and read errors are expected
+	io.WriteString(conn, strings.Repeat("z", 5000)+"POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	rest, _ := io.ReadAll(conn)
+	assert.Empty(t, rest)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	writeErr := make(chan error, 1)
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Connection": {"close"}})
+
+		// Keep streaming until the client's buffers fill up and a write stalls
+		chunk := make([]byte, 1024*1024)
+		for i := 0; i < 1024; i++ {
+			if _, err := w.WriteBody(chunk); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}, Options{WriteTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Client that never reads gets cut off with a SlowConsumerError
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	select {
+	case err := <-writeErr:
+		var slow *SlowConsumerError
+		require.ErrorAs(t, err, &slow)
+		assert.Equal(t, 50*time.Millisecond, slow.Timeout)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler write never timed out")
+	}
+}
+
+func TestChunkedResponse(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Transfer-Encoding": {"chunked"}})
+		w.WriteChunkedBody([]byte(req.RequestLine.RequestTarget))
+		w.WriteChunkedBodyDone()
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Finished chunked responses keep the connection alive
+	resp := roundTrip(t, s, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\nGET /two HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\n/one\r\n0\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\n/two\r\n0\r\n\r\n", resp)
+}
+
+func TestMultipleListeners(t *testing.T) {
+	s := NewServer(func(w *response.Writer, req *request.Request) {
+		body := "you asked for " + req.RequestLine.RequestTarget
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}, Options{})
+	require.NoError(t, s.Listen("tcp", "127.0.0.1:0"))
+	require.NoError(t, s.Listen("tcp", "127.0.0.1:0"))
+	require.NoError(t, s.Listen("unix", filepath.Join(t.TempDir(), "server.sock")))
+	defer s.Close()
+
+	// Test: Every listener is served by the same handler
+	addrs := s.Addrs()
+	require.Len(t, addrs, 3)
+	assert.Equal(t, addrs[0], s.Addr())
+	for _, addr := range addrs {
+		resp := roundTripAddr(t, addr, "GET /"+addr.Network()+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasSuffix(resp, "you asked for /"+addr.Network()))
+	}
+
+	// Test: Close stops all of them and no listener can be added afterwards
+	require.NoError(t, s.Close())
+	for _, addr := range addrs {
+		_, err := net.Dial(addr.Network(), addr.String())
+		assert.Error(t, err)
+	}
+	assert.Equal(t, ERROR_SERVER_CLOSED, s.Listen("tcp", "127.0.0.1:0"))
+}
+
+func TestDraining(t *testing.T) {
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		body := "you asked for " + req.RequestLine.RequestTarget
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {strconv.Itoa(len(body))}})
+		w.WriteBody([]byte(body))
+	}, Options{DrainingRetryAfter: 1500 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: A kept-alive connection gets a 503 for its next request once draining starts
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	first := "HTTP/1.1 200 OK\r\nContent-Length: 18\r\n\r\nyou asked for /one"
+	resp := make([]byte, len(first))
+	_, err = io.ReadFull(conn, resp)
+	require.NoError(t, err)
+	assert.Equal(t, first, string(resp))
+
+	s.SetDraining(true)
+	_, err = io.WriteString(conn, "GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(rest), "HTTP/1.1 503 Service Unavailable\r\n")
+	assert.Contains(t, string(rest), "Retry-After: 2\r\n")
+	assert.Contains(t, string(rest), "Connection: close\r\n")
+	assert.NotContains(t, string(rest), "you asked for")
+
+	// Test: Turning draining off serves requests again
+	s.SetDraining(false)
+	assert.True(t, strings.HasSuffix(roundTrip(t, s, "GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"), "you asked for /three"))
+}
+
+func TestClock(t *testing.T) {
+	fake := clock.NewFake(time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC))
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {}, Options{Clock: fake})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The server's own responses are dated by the injected clock
+	resp := roundTrip(t, s, "GET HTTP/1.1\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request\r\n")
+	assert.Contains(t, resp, "Date: Sun, 06 Nov 1994 08:49:37 GMT\r\n")
+
+	fake.Advance(24 * time.Hour)
+	s.SetDraining(true)
+	resp = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "Date: Mon, 07 Nov 1994 08:49:37 GMT\r\n")
+}
+
+func TestHTTP10(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		h := headers.Headers{}
+		if req.RequestLine.RequestTarget == "/stream" {
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("streamed"))
+			w.WriteChunkedBodyDone()
+			return
+		}
+		h.Set("Content-Length", "2")
+		if req.RequestLine.RequestTarget == "/keep" {
+			h.Set("Connection", "keep-alive")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("ok"))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: HTTP/1.0 connections close after one response by default
+	resp := roundTrip(t, s, "GET / HTTP/1.0\r\n\r\nGET / HTTP/1.0\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: ...unless both sides say keep-alive
+	resp = roundTrip(t, s, "GET /keep HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET / HTTP/1.0\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Chunked responses are downgraded to close-delimited
+	resp = roundTrip(t, s, "GET /stream HTTP/1.0\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nstreamed", resp)
+
+	// Test: Unsupported versions get a 505
+	resp = roundTrip(t, s, "GET / HTTP/2.0\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 505 HTTP Version Not Supported\r\n")
+}
+
+func TestReadTimeouts(t *testing.T) {
+	bodyErr := make(chan error, 1)
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		_, err := io.ReadAll(req.BodyReader())
+		bodyErr <- err
+	}, Options{
+		ReadHeaderTimeout: 50 * time.Millisecond,
+		ReadTimeout:       150 * time.Millisecond,
+		StreamRequestBody: true,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Headers trickling in too slowly get the connection closed
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	start := time.Now()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: loc")
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, resp)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: A client that connects and sends nothing gets the connection closed,
+	// even without an IdleTimeout
+	silent, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer silent.Close()
+	start = time.Now()
+	resp, err = io.ReadAll(silent)
+	require.NoError(t, err)
+	assert.Empty(t, resp)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: A body that stalls fails the handler's read once ReadTimeout passes
+	conn2, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn2.Close()
+	_, err = io.WriteString(conn2, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc")
+	require.NoError(t, err)
+	select {
+	case err := <-bodyErr:
+		assert.True(t, isTimeout(err), "expected a timeout, got %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("body read never timed out")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {"2"}})
+		w.WriteBody([]byte("ok"))
+	})
+	require.NoError(t, err)
+	addr := s.Addr().String()
+
+	// An idle kept-alive connection and one with a request in flight
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = io.WriteString(idle, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp := make([]byte, len("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	_, err = io.ReadFull(idle, resp)
+	require.NoError(t, err)
+
+	busy, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer busy.Close()
+	_, err = io.WriteString(busy, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	// Test: Idle connections are closed and new ones refused straight away
+	_, err = io.ReadAll(idle)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Test: Shutdown waits for the in-flight request, which still gets its response
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a request was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	rest, err := io.ReadAll(busy)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", string(rest))
+	require.NoError(t, <-done)
+
+	// Test: An expired context closes the connections still in flight
+	hang := make(chan struct{})
+	defer close(hang)
+	s, err = Serve(0, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-hang
+	})
+	require.NoError(t, err)
+	stuck, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer stuck.Close()
+	_, err = io.WriteString(stuck, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	rest, err = io.ReadAll(stuck)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+// lateListener hands out the connections sent on conns, even after it's been
+// closed, like a listener whose Accept returned just as Shutdown began
+type lateListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func (l lateListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+
+func TestShutdownRefusesLateConns(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	late := lateListener{listener, make(chan net.Conn)}
+	defer close(late.conns)
+	s := NewServer(func(w *response.Writer, req *request.Request) {
+		t.Error("handler called after Shutdown")
+	}, Options{})
+	require.NoError(t, s.ServeListener(late))
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	// Test: A connection accepted once Shutdown started is closed, not served
+	client, server := net.Pipe()
+	defer client.Close()
+	late.conns <- server
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 0, s.Stats().OpenConnections)
+}
+
+// noDeadlineListener hands out connections that ignore read deadlines,
+// like some wrapped net.Conns do
+type noDeadlineListener struct{ net.Listener }
+
+type noDeadlineConn struct{ net.Conn }
+
+func (l noDeadlineListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return noDeadlineConn{conn}, nil
+}
+
+func (noDeadlineConn) SetReadDeadline(time.Time) error { return nil }
+
+// failingListener is a listener whose Accept always fails, like one that ran
+// out of file descriptors, counting the calls
+type failingListener struct {
+	net.Listener
+	accepts atomic.Int64
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	return nil, fmt.Errorf("accept: too many open files")
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	// Test: The delay starts small, doubles and is capped
+	delay := nextAcceptDelay(0)
+	assert.Equal(t, 5*time.Millisecond, delay)
+	assert.Equal(t, 10*time.Millisecond, nextAcceptDelay(delay))
+	assert.Equal(t, time.Second, nextAcceptDelay(640*time.Millisecond))
+	assert.Equal(t, time.Second, nextAcceptDelay(time.Second))
+
+	// Test: A failing Accept is retried with backoff instead of spinning
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	failing := &failingListener{Listener: listener}
+	s := NewServer(func(w *response.Writer, req *request.Request) {}, Options{})
+	require.NoError(t, s.ServeListener(failing))
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, s.Close())
+
+	// 5+10+20+40ms fit in 100ms, so about five attempts
+	assert.LessOrEqual(t, failing.accepts.Load(), int64(7))
+	assert.GreaterOrEqual(t, failing.accepts.Load(), int64(2))
+}
+
+func TestIdleReaper(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewServer(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {"2"}})
+		w.WriteBody([]byte("ok"))
+	}, Options{IdleTimeout: 100 * time.Millisecond})
+	require.NoError(t, s.ServeListener(noDeadlineListener{listener}))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp := make([]byte, len("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	_, err = io.ReadFull(conn, resp)
+	require.NoError(t, err)
+
+	// Test: The kept-alive connection is counted as open and idle
+	require.Eventually(t, func() bool {
+		stats := s.Stats()
+		return stats.OpenConnections == 1 && stats.IdleConnections == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// Test: The reaper closes it although the read deadline never fires
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, Stats{ReapedConnections: 1}, s.Stats())
+}
+
+func TestMaxConcurrentConnections(t *testing.T) {
+	started := make(chan struct{}, 1)
+	newServer := func(release chan struct{}, options Options) *Server {
+		s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.RequestTarget == "/slow" {
+				started <- struct{}{}
+				<-release
+			}
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(headers.Headers{"Content-Length": {"2"}, "Connection": {"close"}})
+			w.WriteBody([]byte("ok"))
+		}, options)
+		require.NoError(t, err)
+		return s
+	}
+	send := func(s *Server, raw string) net.Conn {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		_, err = io.WriteString(conn, raw)
+		require.NoError(t, err)
+		return conn
+	}
+	ok := "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok"
+
+	// Test: Past the limit connections wait until a slot frees up
+	release := make(chan struct{})
+	s := newServer(release, Options{MaxConcurrentConnections: 1})
+	defer s.Close()
+	busy := send(s, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	defer busy.Close()
+	<-started
+	waiting := send(s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	defer waiting.Close()
+
+	waiting.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := waiting.Read(make([]byte, 1))
+	assert.True(t, isTimeout(err), "no response while the slot is taken")
+	waiting.SetReadDeadline(time.Time{})
+
+	close(release)
+	resp, err := io.ReadAll(waiting)
+	require.NoError(t, err)
+	assert.Equal(t, ok, string(resp))
+
+	// Test: With an accept queue, connections past the queue are turned away with 503
+	release = make(chan struct{})
+	s = newServer(release, Options{MaxConcurrentConnections: 1, MaxAcceptQueue: 1})
+	defer s.Close()
+	busy = send(s, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	defer busy.Close()
+	<-started
+	queued := send(s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	defer queued.Close()
+	require.Eventually(t, func() bool { return len(s.queue) == 1 }, time.Second, 5*time.Millisecond)
+
+	rejected := send(s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	defer rejected.Close()
+	resp, err = io.ReadAll(rejected)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 503 Service Unavailable\r\n")
+
+	close(release)
+	resp, err = io.ReadAll(queued)
+	require.NoError(t, err)
+	assert.Equal(t, ok, string(resp))
+}
+
+func TestBodySinks(t *testing.T) {
+	dir := t.TempDir()
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		// Read the body twice through the seekable handle
+		first, _ := io.ReadAll(req.BodyFile())
+		req.BodyFile().Seek(0, io.SeekStart)
+		second, _ := io.ReadAll(req.BodyFile())
+		body := string(first) + "|" + string(second)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {strconv.Itoa(len(body))}})
+		w.WriteBody([]byte(body))
+	}, Options{BodySinks: &request.BodySinkPolicy{TempDir: dir}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The upload is spooled to a temp file, removed once the handler returns
+	resp := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello|hello"), resp)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Test: A sink that can't be created is a 500 that doesn't leak the path
+	missing := filepath.Join(dir, "missing")
+	s2, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		t.Error("handler called despite the sink failing")
+	}, Options{BodySinks: &request.BodySinkPolicy{TempDir: missing}})
+	require.NoError(t, err)
+	defer s2.Close()
+	resp = roundTrip(t, s2, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 Internal Server Error\r\n"), resp)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nInternal Server Error"), resp)
+	assert.NotContains(t, resp, missing)
+}
+
+// writeTestCert writes a self-signed certificate for example.test and its key as PEM files
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		DNSNames:     []string{"example.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	s, err := ServeTLS(0, certFile, keyFile, func(w *response.Writer, req *request.Request) {
+		body := "plain"
+		if req.TLS != nil {
+			body = fmt.Sprintf("%s %s", tls.VersionName(req.TLS.Version), req.TLS.ServerName)
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {strconv.Itoa(len(body))}, "Connection": {"close"}})
+		w.WriteBody([]byte(body))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Requests arrive over TLS with the connection state attached
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		ServerName:         "example.test",
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.test\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(resp), "\r\n\r\nTLS 1.3 example.test"), string(resp))
+
+	// Test: A plaintext request fails the handshake and gets no answer
+	raw := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.NotContains(t, raw, "HTTP/1.1 200")
+
+	// Test: Missing certificate files
+	_, err = ServeTLS(0, filepath.Join(t.TempDir(), "missing.pem"), keyFile, nil)
+	assert.Error(t, err)
+}
+
+func TestConnectionMetadata(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		body := fmt.Sprintf("%s %s %t", req.RemoteAddr, req.LocalAddr, req.TLS != nil)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {strconv.Itoa(len(body))}, "Connection": {"close"}})
+		w.WriteBody([]byte(body))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The handler sees both ends of the connection, which isn't TLS
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	want := fmt.Sprintf("%s %s false", conn.LocalAddr(), conn.RemoteAddr())
+	assert.True(t, strings.HasSuffix(string(resp), "\r\n\r\n"+want), string(resp))
+}
+
+func TestUpgrade(t *testing.T) {
+	afterHijack := make(chan error, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, rw, err := Upgrade(w, req, "echo")
+		if err != nil {
+			body := err.Error()
+			w.WriteStatusLine(response.StatusUpgradeRequired)
+			w.WriteHeaders(headers.Headers{"Content-Length": {strconv.Itoa(len(body))}, "Connection": {"close"}})
+			w.WriteBody([]byte(body))
+			return
+		}
+		_, err = w.WriteBody([]byte("too late"))
+		afterHijack <- err
+
+		// Echo lines until the client hangs up, from a goroutine that outlives the handler
+		go func() {
+			defer conn.Close()
+			for {
+				line, err := rw.ReadString('\n')
+				if err != nil {
+					return
+				}
+				rw.WriteString("echo: " + line)
+				rw.Flush()
+			}
+		}()
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: 101 then the new protocol, including bytes sent along with the request
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /echo HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nfirst\n")
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	head := ""
+	for !strings.HasSuffix(head, "\r\n\r\n") {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		head += line
+	}
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n", head)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: first\n", line)
+
+	// Test: The stream is full duplex and outlives the handler, and the Writer is done with
+	_, err = io.WriteString(conn, "second\n")
+	require.NoError(t, err)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: second\n", line)
+	assert.ErrorIs(t, <-afterHijack, response.ERROR_HIJACKED)
+
+	// Test: Shutdown doesn't wait for hijacked connections
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	_, err = io.WriteString(conn, "third\n")
+	require.NoError(t, err)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: third\n", line)
+
+	// Test: Requests that don't ask for the protocol aren't upgraded
+	s, err = Serve(0, s.handler)
+	require.NoError(t, err)
+	defer s.Close()
+	for _, raw := range []string{
+		"GET /echo HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"GET /echo HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n",
+		"GET /echo HTTP/1.0\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n",
+	} {
+		resp := roundTrip(t, s, raw)
+		assert.Contains(t, resp, "HTTP/1.1 426 Upgrade Required\r\n", raw)
+	}
+}
+
+func TestOnPanic(t *testing.T) {
+	type report struct {
+		target string
+		value  any
+		stack  string
+	}
+	reports := make(chan report, 1)
+	s, err := ServeWithOptions(0, func(w *response.Writer, req *request.Request) {
+		panicInHandler()
+	}, Options{OnPanic: func(req *request.Request, value any, stack []byte) {
+		reports <- report{req.RequestLine.RequestTarget, value, string(stack)}
+	}})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The client gets a 500 and the panic is reported with where it happened
+	resp := roundTrip(t, s, "GET /boom HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 500 Internal Server Error\r\n")
+	assert.Contains(t, resp, "Connection: close\r\n")
+	r := <-reports
+	assert.Equal(t, "/boom", r.target)
+	assert.Equal(t, "handler blew up", r.value)
+	assert.Contains(t, r.stack, "panicInHandler")
+}
+
+// panicInHandler gives the panic a recognisable frame in the stack trace
+func panicInHandler() {
+	panic("handler blew up")
+}
+
+func TestParseErrorResponses(t *testing.T) {
+	// Test: Wrapped errors match their entry
+	r := ParseErrorResponseFor(fmt.Errorf("%w: 9000 bytes", request.ERROR_TARGET_TOO_LONG))
+	assert.Equal(t, response.StatusURITooLong, r.Status)
+	assert.Equal(t, "request target too long", r.Reason)
+	assert.True(t, r.Close)
+
+	// Test: Unknown errors get the default, carrying the error
+	unknown := fmt.Errorf("ERROR: Something New")
+	r = ParseErrorResponseFor(unknown)
+	assert.Equal(t, response.StatusBadRequest, r.Status)
+	assert.Equal(t, unknown, r.Err)
+
+	// Test: Every entry is complete
+	for _, r := range ParseErrorResponses {
+		assert.NotNil(t, r.Err)
+		assert.NotEmpty(t, r.Status.ReasonPhrase(), r.Reason)
+		assert.NotEmpty(t, r.Reason, r.Err)
+	}
+
+	// Test: The server answers through the table
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"Content-Length": {"0"}})
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	resp := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 501 Not Implemented\r\n")
+	assert.Contains(t, resp, "Connection: close\r\n")
+	assert.Contains(t, resp, request.ERROR_UNSUPPORTED_TRANSFER_ENCODING.Error())
+
+	// Test: A blank Content-Length gets a 400 and the smuggled request is never served
+	resp = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: \r\n\r\n"+
+		"GET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1 "))
+
+	// Test: A missing Host keeps the connection open by default
+	resp = roundTrip(t, s, "GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+	assert.Equal(t, 2, strings.Count(resp, "HTTP/1.1 "), resp)
+
+	// Test: Entries can be overridden, including whether they close the connection
+	saved := slices.Clone(ParseErrorResponses)
+	defer func() { ParseErrorResponses = saved }()
+	for i := range ParseErrorResponses {
+		if ParseErrorResponses[i].Err == request.ERROR_MISSING_HOST {
+			ParseErrorResponses[i].Close = true
+		}
+	}
+	resp = roundTrip(t, s, "GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1 "), resp)
+	assert.Contains(t, resp, "Connection: close\r\n")
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the
+// in-flight requests have finished
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully stops the server: it closes every listener so no new
+// connections are accepted, closes connections sitting idle between requests
+// and then waits for the requests being handled to finish. Each of those
+// connections is closed once its response is written, instead of waiting for
+// another request.
+// If ctx expires first the remaining connections are closed regardless and
+// ctx.Err() is returned. Close only does the first step.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	err := s.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultTLSHandshakeTimeout bounds the TLS handshake when neither
+// ReadHeaderTimeout nor ReadTimeout is set
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+// ServeTLS is Serve over TLS, with the certificate and key read from PEM files
+func ServeTLS(port int, certFile, keyFile string, handler Handler) (*Server, error) {
+	return ServeTLSWithOptions(port, certFile, keyFile, handler, Options{})
+}
+
+// ServeTLSWithOptions is ServeWithOptions over TLS, see ServeTLS
+func ServeTLSWithOptions(port int, certFile, keyFile string, handler Handler, options Options) (*Server, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewServer(handler, options)
+	if err := s.ListenTLS("tcp", fmt.Sprintf(":%d", port), &tls.Config{Certificates: []tls.Certificate{cert}}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListenTLS is Listen wrapping every accepted connection in TLS with config.
+// Handlers find the negotiated version, SNI server name and peer certificates in req.TLS.
+func (s *Server) ListenTLS(network, address string, config *tls.Config) error {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(tls.NewListener(listener, config))
+}
+
+// handshake completes the TLS handshake on a connection accepted through
+// ListenTLS (other connections are left alone) and returns its state.
+// The handshake gets the same deadline as reading a request head, so a
+// client that connects and says nothing can't hold the connection open.
+func (s *Server) handshake(conn net.Conn) (*tls.ConnectionState, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+
+	timeout := s.options.ReadHeaderTimeout
+	if timeout == 0 && s.options.ReadTimeout == 0 {
+		timeout = DefaultTLSHandshakeTimeout
+	}
+	tlsConn.SetDeadline(s.readDeadline(time.Now(), timeout))
+	defer tlsConn.SetDeadline(time.Time{})
+
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	state := tlsConn.ConnectionState()
+	return &state, nil
+}
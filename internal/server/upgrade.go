@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+
+	"github.com/jrooke/httpfromtcp/internal/headers"
+	"github.com/jrooke/httpfromtcp/internal/request"
+	"github.com/jrooke/httpfromtcp/internal/response"
+)
+
+// ERROR_NOT_UPGRADE is returned by Upgrade for a request that didn't ask to switch to the protocol
+var ERROR_NOT_UPGRADE = fmt.Errorf("ERROR: Request does not ask to upgrade to the protocol")
+
+// Upgrade switches the connection req came in on to another protocol
+// (RFC 9110 section 7.8): it checks the request asked for protocol with
+// "Connection: Upgrade" and "Upgrade: <protocol>", answers 101 Switching
+// Protocols and hijacks the connection, see response.Writer.Hijack.
+// Nothing is written if the request didn't ask for protocol (or is HTTP/1.0,
+// which can't upgrade); the handler should answer it normally, usually with
+// 426 Upgrade Required.
+//
+// The caller owns the connection afterwards and must close it. Read through
+// the returned reader, it starts with anything the client sent right after
+// the request, and set deadlines as the protocol needs, the server's
+// timeouts no longer apply.
+func Upgrade(w *response.Writer, req *request.Request, protocol string) (net.Conn, *bufio.ReadWriter, error) {
+	if req.IsHTTP10() || !hasToken(req.Headers.Get("Connection"), "upgrade") || !hasToken(req.Headers.Get("Upgrade"), protocol) {
+		return nil, nil, fmt.Errorf("%w: %q", ERROR_NOT_UPGRADE, protocol)
+	}
+
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, nil, err
+	}
+	if err := w.WriteHeaders(headers.Headers{"Connection": {"Upgrade"}, "Upgrade": {protocol}}); err != nil {
+		return nil, nil, err
+	}
+	return w.Hijack()
+}
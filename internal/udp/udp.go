@@ -0,0 +1,79 @@
+package udp
+
+import (
+	"errors"
+	"log"
+	"net"
+)
+
+// Parser receives each datagram as an independent message
+// Input (from net.Addr): the address the datagram was sent from
+// Input (data []byte): the datagram payload, owned by the parser
+// Returns: an error if the payload could not be parsed
+type Parser func(from net.Addr, data []byte) error
+
+// MaxDatagramSize is the largest payload a single UDP datagram can carry
+const MaxDatagramSize = 65507
+
+// Listener receives UDP datagrams on a local address and
+// hands each one to a Parser. Unlike TCP there is no stream to
+// reassemble: every datagram is a complete message on its own.
+type Listener struct {
+	conn   *net.UDPConn
+	parser Parser
+}
+
+// Listen binds a UDP socket on addr (e.g. ":42069") and returns a Listener
+// that will feed datagrams to parser once Serve is called
+func Listen(addr string, parser Parser) (*Listener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Listener{
+		conn:   conn,
+		parser: parser,
+	}, nil
+}
+
+// Addr returns the local address the listener is bound to
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Serve reads datagrams until the listener is closed.
+// Parse errors are logged and do not stop the loop, since one bad
+// datagram says nothing about the next. Returns nil after Close.
+func (l *Listener) Serve() error {
+	buf := make([]byte, MaxDatagramSize)
+
+	for {
+		n, from, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		// Copy the payload out so the parser can keep it
+		// after buf is reused for the next datagram
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		if err := l.parser(from, data); err != nil {
+			log.Printf("udp: error parsing datagram from %s: %v", from, err)
+		}
+	}
+}
+
+// Close stops the listener, causing Serve to return
+func (l *Listener) Close() error {
+	return l.conn.Close()
+}
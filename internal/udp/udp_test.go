@@ -0,0 +1,43 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerServe(t *testing.T) {
+	received := make(chan string, 3)
+	l, err := Listen("127.0.0.1:0", func(from net.Addr, data []byte) error {
+		if string(data) == "bad" {
+			return fmt.Errorf("bad datagram")
+		}
+		received <- string(data)
+		return nil
+	})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- l.Serve()
+	}()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: Each datagram is delivered as its own message, parse errors don't stop the loop
+	for _, msg := range []string{"GET / HTTP/1.1\r\n", "bad", "Host: localhost\r\n"} {
+		_, err = conn.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "GET / HTTP/1.1\r\n", <-received)
+	assert.Equal(t, "Host: localhost\r\n", <-received)
+
+	// Test: Close makes Serve return without an error
+	require.NoError(t, l.Close())
+	assert.NoError(t, <-done)
+}
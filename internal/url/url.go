@@ -0,0 +1,117 @@
+package url
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jrooke/httpfromtcp/internal/urlenc"
+)
+
+// A request target (RFC 9112 section 3.2) split into its components once,
+// so the router, Host lookup and query parsing all agree on what it says.
+
+// ERROR_MALFORMED_URL is returned for a target with a bad percent-encoding in its path
+var ERROR_MALFORMED_URL = fmt.Errorf("ERROR: Malformed URL")
+
+// URL is a parsed request target
+// Example: "http://user@example.com:8080/a%20b?q=1#top" →
+//
+//	Scheme:   "http"
+//	Host:     "example.com:8080"
+//	Path:     "/a b"
+//	RawPath:  "/a%20b"
+//	RawQuery: "q=1"
+//	Fragment: "top"
+//
+// An origin-form target ("/a?q=1") has no Scheme or Host, an authority-form
+// one ("example.com:443", CONNECT only) is just a Host, and the asterisk-form
+// ("*", OPTIONS only) has Path "*".
+type URL struct {
+	Scheme string
+
+	// Host is the authority without any userinfo ("user:pass@")
+	Host string
+
+	// Path is RawPath with percent-encoding decoded
+	Path     string
+	RawPath  string
+	RawQuery string
+
+	// Fragment is never supposed to be sent, but some clients do
+	Fragment string
+}
+
+// Parse splits a request target into a URL and decodes the path's percent-encoding.
+// An absolute-form target without a path gets "/", as RFC 9112 section 3.2.2 asks.
+// Parse trusts the target was already checked to be in one of the four forms
+// (the request parser does) and only fails on a bad escape.
+func Parse(target string) (*URL, error) {
+	u := &URL{}
+
+	switch {
+	case target == "*":
+		u.Path, u.RawPath = target, target
+		return u, nil
+	case !strings.HasPrefix(target, "/") && !HasScheme(target):
+		// authority-form
+		u.Host = target
+		return u, nil
+	}
+
+	rest, fragment, _ := strings.Cut(target, "#")
+	u.Fragment = fragment
+
+	if HasScheme(rest) {
+		u.Scheme, rest, _ = strings.Cut(rest, "://")
+		authority := rest
+		if i := strings.IndexAny(rest, "/?"); i >= 0 {
+			authority, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+		if i := strings.LastIndexByte(authority, '@'); i >= 0 {
+			authority = authority[i+1:]
+		}
+		u.Host = authority
+		if !strings.HasPrefix(rest, "/") {
+			rest = "/" + rest
+		}
+	}
+	u.RawPath, u.RawQuery, _ = strings.Cut(rest, "?")
+
+	path, err := urlenc.PathUnescape(u.RawPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ERROR_MALFORMED_URL, err)
+	}
+	u.Path = path
+	return u, nil
+}
+
+// RequestURI returns the path and query as they'd appear in an origin-form target
+// Example: "http://example.com/a%20b?q=1" → "/a%20b?q=1"
+func (u *URL) RequestURI() string {
+	if u.RawQuery == "" {
+		return u.RawPath
+	}
+	return u.RawPath + "?" + u.RawQuery
+}
+
+// HasScheme reports whether target starts with "scheme://"
+// where scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+func HasScheme(target string) bool {
+	scheme, _, ok := strings.Cut(target, "://")
+	if !ok || scheme == "" {
+		return false
+	}
+	for i := 0; i < len(scheme); i++ {
+		c := scheme[i]
+		letter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if i == 0 && !letter {
+			return false
+		}
+		if !letter && !(c >= '0' && c <= '9') && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package url
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Each target form
+	for _, tc := range []struct {
+		target string
+		want   URL
+	}{
+		{"/search%20me?q=go%26more&page=2#top", URL{Path: "/search me", RawPath: "/search%20me", RawQuery: "q=go%26more&page=2", Fragment: "top"}},
+		{"/", URL{Path: "/", RawPath: "/"}},
+		{"http://user:pw@example.com:8080/a%20b?q=1#top", URL{Scheme: "http", Host: "example.com:8080", Path: "/a b", RawPath: "/a%20b", RawQuery: "q=1", Fragment: "top"}},
+		{"http://example.com", URL{Scheme: "http", Host: "example.com", Path: "/", RawPath: "/"}},
+		{"http://example.com?q=1", URL{Scheme: "http", Host: "example.com", Path: "/", RawPath: "/", RawQuery: "q=1"}},
+		{"example.com:443", URL{Host: "example.com:443"}},
+		{"*", URL{Path: "*", RawPath: "*"}},
+	} {
+		u, err := Parse(tc.target)
+		require.NoError(t, err, tc.target)
+		assert.Equal(t, tc.want, *u, tc.target)
+	}
+
+	// Test: Bad escapes in the path
+	for _, target := range []string{"/%", "/%2", "/%zz", "http://example.com/a%2"} {
+		_, err := Parse(target)
+		assert.ErrorIs(t, err, ERROR_MALFORMED_URL, target)
+	}
+
+	// Test: RequestURI drops the scheme, authority and fragment
+	u, err := Parse("http://example.com/a%20b?q=1#top")
+	require.NoError(t, err)
+	assert.Equal(t, "/a%20b?q=1", u.RequestURI())
+	u, err = Parse("/plain")
+	require.NoError(t, err)
+	assert.Equal(t, "/plain", u.RequestURI())
+
+	// Test: Schemes
+	assert.True(t, HasScheme("svn+ssh://example.com"))
+	assert.False(t, HasScheme("1http://example.com"))
+	assert.False(t, HasScheme("example.com:443"))
+}
@@ -0,0 +1,139 @@
+package urlenc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Percent-encoding (RFC 3986 section 2.1) for paths and query strings,
+// shared by the request target and query parsers.
+
+// ERROR_BAD_ESCAPE is returned for a "%" not followed by two hex digits
+var ERROR_BAD_ESCAPE = fmt.Errorf("ERROR: Bad Percent-Encoding")
+
+// Options tune how strictly escaped input is decoded
+// The zero value is strict, which is what the package level functions use.
+type Options struct {
+	// Lenient keeps malformed escapes ("%zz", a trailing "%") as literal
+	// text instead of failing with ERROR_BAD_ESCAPE
+	Lenient bool
+
+	// KeepEncodedSlash leaves "%2F" undecoded in paths, so the decoded path
+	// still splits into the same segments the client sent
+	KeepEncodedSlash bool
+}
+
+// mode is which component a string is escaped for
+type mode int
+
+const (
+	modePath mode = iota
+	modeQuery
+)
+
+// QueryEscape escapes s for use as a query key or value: space becomes "+"
+// and everything but unreserved characters is percent-encoded
+// Example: "a b&c" → "a+b%26c"
+func QueryEscape(s string) string {
+	return escape(s, modeQuery)
+}
+
+// QueryUnescape decodes a query key or value: "+" becomes a space and %XX is decoded
+func QueryUnescape(s string) (string, error) {
+	return Options{}.QueryUnescape(s)
+}
+
+// PathEscape escapes s for use as one path segment, so "/" is encoded too
+// Example: "a b/c" → "a%20b%2Fc"
+func PathEscape(s string) string {
+	return escape(s, modePath)
+}
+
+// PathUnescape decodes a path: %XX is decoded and "+" stays a "+"
+func PathUnescape(s string) (string, error) {
+	return Options{}.PathUnescape(s)
+}
+
+// QueryUnescape is the package level QueryUnescape following o
+func (o Options) QueryUnescape(s string) (string, error) {
+	return o.unescape(s, modeQuery)
+}
+
+// PathUnescape is the package level PathUnescape following o
+func (o Options) PathUnescape(s string) (string, error) {
+	return o.unescape(s, modePath)
+}
+
+// unescape decodes %XX sequences (and "+" in queries)
+func (o Options) unescape(s string, m mode) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '+' && m == modeQuery:
+			b = append(b, ' ')
+		case c != '%':
+			b = append(b, c)
+		case i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]):
+			if !o.Lenient {
+				return "", fmt.Errorf("%w in %q", ERROR_BAD_ESCAPE, s)
+			}
+			b = append(b, c)
+		default:
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if decoded == '/' && m == modePath && o.KeepEncodedSlash {
+				b = append(b, s[i:i+3]...)
+			} else {
+				b = append(b, decoded)
+			}
+			i += 2
+		}
+	}
+	return string(b), nil
+}
+
+// escape percent-encodes everything but unreserved characters
+// (letters, digits and -._~), plus space as "+" in queries
+func escape(s string, m mode) string {
+	const upperhex = "0123456789ABCDEF"
+
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isUnreserved(c):
+			b = append(b, c)
+		case c == ' ' && m == modeQuery:
+			b = append(b, '+')
+		default:
+			b = append(b, '%', upperhex[c>>4], upperhex[c&15])
+		}
+	}
+	return string(b)
+}
+
+// isUnreserved reports whether c never needs escaping (RFC 3986 section 2.3)
+func isUnreserved(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// isHex reports whether c is a hexadecimal digit
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// unhex returns the value of the hexadecimal digit c
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,55 @@
+package urlenc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscape(t *testing.T) {
+	// Test: Query escaping uses "+" for spaces
+	assert.Equal(t, "a+b%26c%3Dd", QueryEscape("a b&c=d"))
+	assert.Equal(t, "caf%C3%A9~-._", QueryEscape("café~-._"))
+
+	// Test: Path escaping encodes spaces and slashes
+	assert.Equal(t, "a%20b%2Fc%2B", PathEscape("a b/c+"))
+
+	// Test: Round trips
+	for _, s := range []string{"", "plain", "a b+c/d?e#f%g", "ünïcödé"} {
+		q, err := QueryUnescape(QueryEscape(s))
+		require.NoError(t, err)
+		assert.Equal(t, s, q)
+		p, err := PathUnescape(PathEscape(s))
+		require.NoError(t, err)
+		assert.Equal(t, s, p)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	// Test: "+" is a space only in queries
+	q, err := QueryUnescape("a+b%20c")
+	require.NoError(t, err)
+	assert.Equal(t, "a b c", q)
+	p, err := PathUnescape("/a+b%20c")
+	require.NoError(t, err)
+	assert.Equal(t, "/a+b c", p)
+
+	// Test: Strict decoding rejects bad escapes
+	for _, s := range []string{"%", "%2", "%zz", "a%2g"} {
+		_, err = PathUnescape(s)
+		assert.ErrorIs(t, err, ERROR_BAD_ESCAPE, s)
+		_, err = QueryUnescape(s)
+		assert.ErrorIs(t, err, ERROR_BAD_ESCAPE, s)
+	}
+
+	// Test: Lenient decoding keeps bad escapes as text
+	p, err = Options{Lenient: true}.PathUnescape("/100%/%41%zz%")
+	require.NoError(t, err)
+	assert.Equal(t, "/100%/A%zz%", p)
+
+	// Test: Encoded slashes can be kept so path segments survive
+	p, err = Options{KeepEncodedSlash: true}.PathUnescape("/a%2Fb/c%20d")
+	require.NoError(t, err)
+	assert.Equal(t, "/a%2Fb/c d", p)
+}